@@ -0,0 +1,88 @@
+package gziphandler
+
+import (
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// brotliWriterPools stores a sync.Pool for each brotli quality for reuse of
+// brotli.Writers. Qualities start at zero, so a quality is its own index.
+var brotliWriterPools [brotli.BestCompression - brotli.BestSpeed + 1]*sync.Pool
+
+func init() {
+	for q := brotli.BestSpeed; q <= brotli.BestCompression; q++ {
+		addBrotliQualityPool(q)
+	}
+}
+
+// addBrotliQualityPool creates an empty pool for the given quality, which
+// like those of addLevelPool has no New func.
+func addBrotliQualityPool(quality int) {
+	brotliWriterPools[quality] = &sync.Pool{}
+}
+
+// brotliEncoderFactory is the built-in EncoderFactory for the br
+// content-coding. Its Encoders are pooled in brotliWriterPools.
+type brotliEncoderFactory struct {
+	quality int
+}
+
+func (f brotliEncoderFactory) Name() string {
+	return "br"
+}
+
+func (f brotliEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	return brotli.NewWriterLevel(w, f.quality), nil
+}
+
+func (f brotliEncoderFactory) pool() *sync.Pool {
+	return brotliWriterPools[f.quality]
+}
+
+func (f brotliEncoderFactory) compressionLevel() int {
+	return f.quality
+}
+
+// brotliReaderPool stores brotliDecoders for reuse.
+var brotliReaderPool sync.Pool
+
+// brotliDecoderFactory is the built-in DecoderFactory for the br
+// content-coding. Its Decoders are pooled in brotliReaderPool.
+type brotliDecoderFactory struct{}
+
+func (f brotliDecoderFactory) Name() string {
+	return "br"
+}
+
+func (f brotliDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	return brotliDecoder{brotli.NewReader(r)}, nil
+}
+
+func (f brotliDecoderFactory) pool() *sync.Pool {
+	return &brotliReaderPool
+}
+
+// brotliDecoder adapts brotli.Reader, which has nothing to close, to Decoder.
+type brotliDecoder struct {
+	*brotli.Reader
+}
+
+func (d brotliDecoder) Close() error {
+	return nil
+}
+
+// BrotliLevel enables the br content-coding, compressing at the given brotli
+// quality (0 to 11). brotli.DefaultCompression is a good trade-off between
+// speed and size for responses compressed on the fly.
+//
+// When a client accepts both br and gzip with the same qvalue, br is
+// preferred unless PreferredEncodings says otherwise. zstd, if enabled, is
+// preferred over br.
+func BrotliLevel(quality int) option {
+	return scoped("BrotliLevel", compressing|forTransport, func(c *config) {
+		c.brotli = true
+		c.brotliLevel = quality
+	})
+}
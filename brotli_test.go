@@ -0,0 +1,113 @@
+package gziphandler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrotliLevel(t *testing.T) {
+	for q := brotli.BestSpeed; q <= brotli.BestCompression; q++ {
+		wrapper, err := GzipHandlerWithOpts(BrotliLevel(q))
+		if !assert.Nil(t, err, "GzipHandlerWithOpts returned error for brotli quality:", q) {
+			continue
+		}
+
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+		resp := httptest.NewRecorder()
+		wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody)
+		})).ServeHTTP(resp, req)
+		res := resp.Result()
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "br", res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+		assert.Equal(t, testBody, brotliDecode(t, resp.Body.Bytes()))
+	}
+}
+
+func TestBrotliLevelReturnsErrorForInvalidLevels(t *testing.T) {
+	_, err := GzipHandlerWithOpts(BrotliLevel(-1))
+	assert.NotNil(t, err, "Expected error for invalid brotli quality")
+	_, err = GzipHandlerWithOpts(BrotliLevel(12))
+	assert.NotNil(t, err, "Expected error for invalid brotli quality")
+}
+
+func TestBrotliNegotiation(t *testing.T) {
+	tests := []struct {
+		opts             []option
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{nil, "gzip, br", "br"},
+		{nil, "br", "br"},
+		{nil, "gzip", "gzip"},
+		{nil, "gzip;q=1.0, br;q=0.8", "gzip"},
+		{nil, "gzip;q=0.5, br;q=0.8", "br"},
+		{nil, "gzip, br;q=0", "gzip"},
+		{[]option{PreferredEncodings("gzip")}, "gzip, br", "gzip"},
+		{[]option{PreferredEncodings("GZIP", "br")}, "br, gzip", "gzip"},
+		{[]option{PreferredEncodings("gzip")}, "gzip;q=0.5, br", "br"},
+	}
+
+	for _, tt := range tests {
+		wrapper, err := GzipHandlerWithOpts(append(tt.opts, BrotliLevel(brotli.DefaultCompression))...)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		resp := httptest.NewRecorder()
+		wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody)
+		})).ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.acceptEncoding)
+	}
+}
+
+func TestBrotliDisabledByDefault(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	resp := httptest.NewRecorder()
+	newTestHandler(testBody).ServeHTTP(resp, req)
+
+	assert.Equal(t, "", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, testBody, resp.Body.String())
+}
+
+func BenchmarkBrotliHandler_S20k(b *testing.B) {
+	bin, err := ioutil.ReadFile("testdata/benchmark.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	wrapper, _ := GzipHandlerWithOpts(BrotliLevel(brotli.DefaultCompression))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bin[:20480])
+	}))
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "br")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runBenchmark(b, req, handler)
+	}
+}
+
+// --------------------------------------------------------------------
+
+func brotliDecode(t *testing.T, b []byte) string {
+	out, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+	assert.Nil(t, err)
+	return string(out)
+}
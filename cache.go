@@ -0,0 +1,239 @@
+package gziphandler
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	authorization = "Authorization"
+	cacheControl  = "Cache-Control"
+	lastModified  = "Last-Modified"
+)
+
+// ResponseCache is an in-memory LRU cache of compressed responses, shared by
+// the handlers configured with CacheResponses. It's safe for concurrent use.
+//
+// Responses are keyed by request method and URL, negotiated content-coding,
+// and the ETag or, failing that, the Last-Modified header set by the handler.
+// The handler still runs on every request, since only it knows the current
+// validator of the resource, but on a hit its output is discarded rather than
+// compressed again, and the cached bytes are sent instead.
+//
+// Only 200 responses to GET requests, with a validator, and without a
+// "private" or "no-store" Cache-Control directive, are cached. Since the key
+// doesn't include the request headers, responses which vary on anything but
+// Accept-Encoding aren't cached, and neither are responses to requests with
+// an Authorization header, unless they're "public" or have an "s-maxage", as
+// RFC 9111 requires of shared caches.
+type ResponseCache struct {
+	budget int64 // Maximum total size of the cached bodies.
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // Of *cacheEntry, most recently used first.
+	entries map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key  string
+	body []byte
+}
+
+// NewResponseCache returns an empty ResponseCache holding at most budget
+// bytes of compressed response bodies. The least recently used responses are
+// evicted to make room for new ones, and responses larger than budget aren't
+// cached at all.
+func NewResponseCache(budget int64) *ResponseCache {
+	return &ResponseCache{
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Hits returns the number of responses served from the cache.
+func (c *ResponseCache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns the number of cacheable responses which weren't in the
+// cache, and were compressed by the handler.
+func (c *ResponseCache) Misses() uint64 {
+	return c.misses.Load()
+}
+
+// Size returns the total size of the cached bodies.
+func (c *ResponseCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *ResponseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheEntry).body, true
+}
+
+func (c *ResponseCache) add(key string, body []byte) {
+	if int64(len(body)) > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.size -= int64(len(e.Value.(*cacheEntry).body))
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, body: body})
+	c.size += int64(len(body))
+
+	for c.size > c.budget {
+		e := c.lru.Back()
+		entry := c.lru.Remove(e).(*cacheEntry)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.body))
+	}
+}
+
+// CacheResponses makes the handler cache the compressed responses it serves
+// in cache, as described by ResponseCache.
+func CacheResponses(cache *ResponseCache) option {
+	return scoped("CacheResponses", compressing, func(c *config) {
+		c.cache = cache
+	})
+}
+
+// cacheKey returns the part of the ResponseCache key of the response to r
+// which is known before the handler runs, or "" if it can't be cached.
+func cacheKey(r *http.Request, pool *encoderPool) string {
+	if r.Method != http.MethodGet {
+		return ""
+	}
+	return r.Method + " " + r.Host + r.URL.RequestURI() + "\x00" + pool.name
+}
+
+// serveCached completes the cache key with the validator of the response,
+// and writes the cached response if there is one, returning true. Otherwise
+// it arranges for the response to be captured as it's compressed, so Close
+// can add it to the cache.
+func (w *GzipResponseWriter) serveCached() (bool, error) {
+	if w.cache == nil || w.cacheKey == "" || w.capture != nil {
+		return false, nil
+	}
+	if w.code != 0 && w.code != http.StatusOK {
+		return false, nil
+	}
+	if !storable(w.decision.Request, w.Header()) {
+		return false, nil
+	}
+	validator := w.Header().Get(etag)
+	if validator == "" {
+		validator = w.Header().Get(lastModified)
+	}
+	if validator == "" {
+		return false, nil
+	}
+
+	key := w.cacheKey + "\x00" + validator
+	body, ok := w.cache.get(key)
+	if !ok {
+		w.cache.misses.Add(1)
+		w.capture = &captureWriter{key: key, limit: w.cache.budget}
+		return false, nil
+	}
+
+	w.cache.hits.Add(1)
+	w.decision.Reason = ReasonCached
+	w.decision.CompressedBytes = int64(len(body))
+	w.discard = true
+	w.buf = nil
+	w.Header().Set(contentLength, strconv.Itoa(len(body)))
+	if w.timing {
+		w.Header().Add(serverTiming, timingEntry(w.decision))
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+		w.code = 0
+	}
+	_, err := w.ResponseWriter.Write(body)
+	return true, err
+}
+
+// storable returns whether a response to r with the given header may be
+// stored, under a key which ignores the headers of r.
+func storable(r *http.Request, h http.Header) bool {
+	if hasCacheDirective(h, "private", "no-store") {
+		return false
+	}
+	for _, v := range h.Values(vary) {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" && !strings.EqualFold(name, acceptEncoding) {
+				return false
+			}
+		}
+	}
+	if r.Header.Get(authorization) != "" && !hasCacheDirective(h, "public", "s-maxage") {
+		return false
+	}
+	return true
+}
+
+// hasCacheDirective returns whether the Cache-Control header in h has any of
+// the given directives, which must be lower-case.
+func hasCacheDirective(h http.Header, directives ...string) bool {
+	for _, v := range h.Values(cacheControl) {
+		for _, directive := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			for _, d := range directives {
+				if name == d {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// captureWriter keeps a copy of the compressed bytes written to the response,
+// until there are more than limit of them.
+type captureWriter struct {
+	io.Writer
+	key      string
+	limit    int64
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	n, err := c.Writer.Write(b)
+	if !c.overflow {
+		if int64(c.buf.Len()+n) > c.limit {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(b[:n])
+		}
+	}
+	return n, err
+}
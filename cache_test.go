@@ -0,0 +1,180 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	wrapper, err := GzipHandlerWithOpts(CacheResponses(cache))
+	assert.Nil(t, err)
+
+	// The handler's body changes without its ETag changing, which shows which
+	// responses come from the cache.
+	body := testBody
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", r.URL.Query().Get("etag"))
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		io.WriteString(w, body)
+	}))
+
+	get := func(method, target, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("GET", "/?etag=a", "gzip")
+	assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), resp.Body.Bytes())
+	assert.Equal(t, uint64(0), cache.Hits())
+	assert.Equal(t, uint64(1), cache.Misses())
+
+	body = strings.ToUpper(testBody)
+	resp = get("GET", "/?etag=a", "gzip")
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), resp.Body.Bytes())
+	assert.Equal(t, int64(resp.Body.Len()), resp.Result().ContentLength)
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, int64(resp.Body.Len()), cache.Size())
+
+	// A different validator, method or URL is a different response.
+	for _, target := range []string{"/?etag=b", "/?etag=a&x"} {
+		resp = get("GET", target, "gzip")
+		assert.Equal(t, gzipStrLevel(body, gzip.DefaultCompression), resp.Body.Bytes(), target)
+	}
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, uint64(3), cache.Misses())
+
+	// HEAD requests aren't cached.
+	get("HEAD", "/?etag=a", "gzip")
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, uint64(3), cache.Misses())
+
+	// Uncompressed responses aren't cached.
+	resp = get("GET", "/?etag=a", "identity")
+	assert.Equal(t, body, resp.Body.String())
+	assert.Equal(t, uint64(3), cache.Misses())
+
+	// Nor are private ones, or those without a validator.
+	for _, target := range []string{"/?etag=c&cc=private", "/?etag=c&cc=no-store,+max-age=60", "/?etag=c&cc=No-Store", "/"} {
+		get("GET", target, "gzip")
+		resp = get("GET", target, "gzip")
+		assert.Equal(t, gzipStrLevel(body, gzip.DefaultCompression), resp.Body.Bytes(), target)
+	}
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, uint64(3), cache.Misses())
+}
+
+func TestResponseCachePerUser(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	wrapper, _ := GzipHandlerWithOpts(CacheResponses(cache))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+		w.Header().Set("Vary", r.URL.Query().Get("vary"))
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		user := r.Header.Get("Cookie") + r.Header.Get("Authorization")
+		io.WriteString(w, user+" "+testBody)
+	}))
+
+	tests := []struct {
+		target, header string
+		shared         bool
+	}{
+		{"/?vary=Cookie", "Cookie", false},
+		{"/?vary=Accept-Encoding,+cookie", "Cookie", false},
+		{"/?vary=*", "Cookie", false},
+		{"/", "Authorization", false},
+		{"/?cc=max-age=60", "Authorization", false},
+		{"/?cc=public", "Authorization", true},
+		{"/?cc=s-maxage=60", "Authorization", true},
+	}
+
+	for _, tt := range tests {
+		for _, user := range []string{"alice", "bob"} {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			req.Header.Set(tt.header, user)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			expected := user + " " + testBody
+			if tt.shared {
+				expected = "alice " + testBody
+			}
+			assert.Equal(t, gzipStrLevel(expected, gzip.DefaultCompression), resp.Body.Bytes(), tt.target+" "+user)
+		}
+	}
+}
+
+func TestResponseCacheLastModified(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	wrapper, _ := GzipHandlerWithOpts(CacheResponses(cache))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		io.WriteString(w, testBody)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), resp.Body.Bytes())
+	}
+	assert.Equal(t, uint64(1), cache.Hits())
+	assert.Equal(t, uint64(1), cache.Misses())
+}
+
+func TestResponseCacheStatusCode(t *testing.T) {
+	cache := NewResponseCache(1 << 20)
+	wrapper, _ := GzipHandlerWithOpts(CacheResponses(cache))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"a"`)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, testBody)
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	}
+	assert.Equal(t, uint64(0), cache.Hits()+cache.Misses())
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := NewResponseCache(10)
+	cache.add("a", []byte("aaaa"))
+	cache.add("b", []byte("bbbb"))
+	cache.get("a")
+	cache.add("c", []byte("cccc"))
+	assert.Equal(t, int64(8), cache.Size())
+
+	_, ok := cache.get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c"} {
+		_, ok := cache.get(key)
+		assert.True(t, ok, key)
+	}
+
+	// Replacing an entry doesn't count it twice.
+	cache.add("c", []byte("cc"))
+	assert.Equal(t, int64(6), cache.Size())
+
+	// Entries larger than the budget aren't cached at all.
+	cache.add("d", []byte("ddddddddddd"))
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, int64(6), cache.Size())
+}
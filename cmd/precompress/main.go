@@ -0,0 +1,179 @@
+// Command precompress writes precompressed siblings of the files in a
+// directory, for gziphandler.FileServer to serve, along with a manifest
+// describing them.
+//
+// For every file, it writes a gzip (".gz"), brotli (".br") and zstd (".zst")
+// sibling compressed at the highest level, unless the sibling isn't at least
+// -min-savings percent smaller than the original, in which case any existing
+// sibling is removed. It's meant to be run with go generate before embedding
+// the directory:
+//
+//	//go:generate go run github.com/NYTimes/gziphandler/cmd/precompress -dir static
+//	//go:embed static
+//	var static embed.FS
+//
+// The manifest can then be handed to FileServer with PrecompressedManifest.
+// Its paths are relative to the directory, so serve the directory itself:
+//
+//	root, err := fs.Sub(static, "static")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	handler, err := gziphandler.FileServer(root,
+//		gziphandler.PrecompressedManifest(gziphandler.DefaultManifestName))
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressors creates a writer for each content-coding, at its highest level.
+var compressors = map[string]func(w io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	},
+	"br": func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	},
+	"zstd": func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	},
+}
+
+func main() {
+	var (
+		dir        = flag.String("dir", ".", "directory whose files to precompress")
+		encodings  = flag.String("encodings", "gzip,br,zstd", "comma-separated content-codings to precompress with")
+		minSavings = flag.Float64("min-savings", 10, "percentage by which a sibling must be smaller than the original to be kept")
+		manifest   = flag.String("manifest", gziphandler.DefaultManifestName, "name of the manifest to write in dir, or empty for none")
+	)
+	flag.Parse()
+
+	m, err := precompress(*dir, strings.Split(*encodings, ","), *minSavings, *manifest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *manifest != "" {
+		if err := writeManifest(filepath.Join(*dir, *manifest), m); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// precompress writes the precompressed siblings of every file in dir and
+// returns the manifest describing them. The file named manifest, and existing
+// siblings, aren't precompressed.
+func precompress(dir string, encodings []string, minSavings float64, manifest string) (*gziphandler.Manifest, error) {
+	exts := make(map[string]string, len(encodings))
+	for _, coding := range encodings {
+		coding = strings.TrimSpace(coding)
+		ext, ok := gziphandler.PrecompressedExtension(coding)
+		if _, supported := compressors[coding]; !ok || !supported {
+			return nil, fmt.Errorf("unsupported content-coding %q", coding)
+		}
+		exts[coding] = ext
+	}
+
+	m := &gziphandler.Manifest{Files: make(map[string]gziphandler.ManifestFile)}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == manifest || isSibling(rel) {
+			return nil
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		f := gziphandler.ManifestFile{
+			Size:     int64(len(b)),
+			SHA256:   hash(b),
+			Variants: make(map[string]gziphandler.ManifestVariant),
+		}
+
+		for coding, ext := range exts {
+			compressed, err := compress(coding, b)
+			if err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+			if float64(len(compressed)) > float64(len(b))*(1-minSavings/100) {
+				if err := os.Remove(p + ext); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				continue
+			}
+			if err := os.WriteFile(p+ext, compressed, 0644); err != nil {
+				return err
+			}
+			f.Variants[coding] = gziphandler.ManifestVariant{
+				Size:   int64(len(compressed)),
+				SHA256: hash(compressed),
+			}
+		}
+
+		m.Files[rel] = f
+		return nil
+	})
+	return m, err
+}
+
+// isSibling returns whether name is that of a precompressed sibling.
+func isSibling(name string) bool {
+	for coding := range compressors {
+		if ext, _ := gziphandler.PrecompressedExtension(coding); strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func compress(coding string, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := compressors[coding](&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeManifest(name string, m *gziphandler.Manifest) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
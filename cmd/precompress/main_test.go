@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecompress(t *testing.T) {
+	dir := t.TempDir()
+	text := strings.Repeat("aaabbbccc", 1000)
+	random := make([]byte, 4096)
+	rand.Read(random)
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "js"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "js", "app.js"), []byte(text), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "image.png"), random, 0644))
+	// A stale sibling, which must be removed.
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "image.png.gz"), []byte("stale"), 0644))
+
+	m, err := precompress(dir, []string{"gzip", "br", "zstd"}, 10, gziphandler.DefaultManifestName)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, writeManifest(filepath.Join(dir, gziphandler.DefaultManifestName), m))
+
+	if assert.Contains(t, m.Files, "js/app.js") {
+		f := m.Files["js/app.js"]
+		assert.Equal(t, int64(len(text)), f.Size)
+		assert.Equal(t, hash([]byte(text)), f.SHA256)
+		assert.Len(t, f.Variants, 3)
+		for coding, v := range f.Variants {
+			ext, _ := gziphandler.PrecompressedExtension(coding)
+			b, err := os.ReadFile(filepath.Join(dir, "js", "app.js"+ext))
+			if assert.Nil(t, err, coding) {
+				assert.Equal(t, int64(len(b)), v.Size, coding)
+				assert.Equal(t, hash(b), v.SHA256, coding)
+			}
+		}
+	}
+	if assert.Contains(t, m.Files, "image.png") {
+		assert.Empty(t, m.Files["image.png"].Variants)
+		_, err := os.Stat(filepath.Join(dir, "image.png.gz"))
+		assert.True(t, os.IsNotExist(err))
+	}
+	assert.Len(t, m.Files, 2)
+
+	// Running again doesn't precompress the siblings or the manifest.
+	m, err = precompress(dir, []string{"gzip", "br", "zstd"}, 10, gziphandler.DefaultManifestName)
+	assert.Nil(t, err)
+	assert.Len(t, m.Files, 2)
+
+	// The result can be served by FileServer.
+	handler, err := gziphandler.FileServer(os.DirFS(dir), gziphandler.PrecompressedManifest(gziphandler.DefaultManifestName))
+	if !assert.Nil(t, err) {
+		return
+	}
+	req := httptest.NewRequest("GET", "/js/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, "br", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"`+m.Files["js/app.js"].Variants["br"].SHA256[:16]+`"`, resp.Header().Get("Etag"))
+}
+
+func TestPrecompressUnsupported(t *testing.T) {
+	_, err := precompress(t.TempDir(), []string{"deflate"}, 10, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,175 @@
+package gziphandler
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// Decoder decompresses the bytes read from an underlying io.Reader. Decoders
+// are pooled and reused across requests, so Reset must discard any previous
+// state and start decoding a new stream read from r.
+//
+// Close must not close the underlying io.Reader, and must leave the Decoder
+// usable after Reset.
+//
+// *gzip.Reader satisfies this interface.
+type Decoder interface {
+	io.ReadCloser
+	Reset(r io.Reader) error
+}
+
+// DecoderFactory creates Decoders for a single content-coding.
+type DecoderFactory interface {
+	// Name returns the content-coding decoded by the Decoders, as it appears
+	// in the Content-Encoding header, e.g. "gzip".
+	Name() string
+
+	// NewDecoder returns a new Decoder which reads from r.
+	NewDecoder(r io.Reader) (Decoder, error)
+}
+
+// decoderPool stores the Decoders created by a single DecoderFactory for reuse.
+type decoderPool struct {
+	name    string // Lower-cased factory.Name().
+	factory DecoderFactory
+	pool    *sync.Pool
+}
+
+func newDecoderPool(f DecoderFactory) *decoderPool {
+	p := &decoderPool{
+		name:    strings.ToLower(f.Name()),
+		factory: f,
+	}
+	if pf, ok := f.(pooledFactory); ok {
+		p.pool = pf.pool()
+	} else {
+		p.pool = &sync.Pool{}
+	}
+	return p
+}
+
+// get returns a Decoder reading from r, reusing a pooled one if possible.
+func (p *decoderPool) get(r io.Reader) (Decoder, error) {
+	if d, ok := p.pool.Get().(Decoder); ok {
+		if err := d.Reset(r); err != nil {
+			// The Decoder is still fine, it's the stream which is broken.
+			p.pool.Put(d)
+			return nil, err
+		}
+		return d, nil
+	}
+	return p.factory.NewDecoder(r)
+}
+
+// put returns a Decoder obtained from get to the pool.
+func (p *decoderPool) put(d Decoder) {
+	p.pool.Put(d)
+}
+
+// decodingReader decodes the bytes read from src with a Decoder from pool.
+// The Decoder is only grabbed on the first Read, so that nothing is read from
+// the request body until the handler asks for it.
+type decodingReader struct {
+	src  io.Reader
+	pool *decoderPool
+	dec  Decoder
+	err  error // Error from grabbing the Decoder, returned by every Read.
+}
+
+func (r *decodingReader) Read(p []byte) (int, error) {
+	if r.dec == nil && r.err == nil {
+		r.dec, r.err = r.pool.get(r.src)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.dec.Read(p)
+}
+
+// Close puts the Decoder back in its pool and closes src, if it's an
+// io.Closer, which it is for the request body and any decodingReaders
+// stacked on top of it.
+func (r *decodingReader) Close() error {
+	var err error
+	if r.dec != nil {
+		err = r.dec.Close()
+		r.pool.put(r.dec)
+		r.dec = nil
+	}
+	if c, ok := r.src.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Decoders registers additional content-codings with the handlers returned by
+// DecompressHandlerWithOpts. The built-in gzip, deflate, br and zstd
+// content-codings are always decoded; a factory with the same Name replaces
+// the built-in one.
+func Decoders(factories ...DecoderFactory) option {
+	return scoped("Decoders", forDecompress|forTransport, func(c *config) {
+		c.decoders = append(c.decoders, factories...)
+	})
+}
+
+// decoderPools returns the pools for every content-coding that can be
+// decoded, registered ones first, followed by the built-in ones in the same
+// order as in encoderPools.
+func (c *config) decoderPools() []*decoderPool {
+	factories := make([]DecoderFactory, 0, len(c.decoders)+4)
+	factories = append(factories, c.decoders...)
+	factories = append(factories,
+		zstdDecoderFactory{maxMemory: c.maxDecompressedSize},
+		brotliDecoderFactory{},
+		gzipDecoderFactory{},
+		deflateDecoderFactory{},
+	)
+
+	pools := make([]*decoderPool, 0, len(factories))
+	seen := make(map[string]bool, len(factories))
+	for _, f := range factories {
+		p := newDecoderPool(f)
+		if seen[p.name] {
+			continue
+		}
+		seen[p.name] = true
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// decoderPoolsByName indexes pools returned by decoderPools by name.
+func decoderPoolsByName(pools []*decoderPool) map[string]*decoderPool {
+	m := make(map[string]*decoderPool, len(pools))
+	for _, p := range pools {
+		m[p.name] = p
+	}
+	return m
+}
+
+// maxContentCodings is the number of stacked content-codings decodeBody
+// undoes at most. Stacking content-codings gains next to nothing, and every
+// one costs a Decoder, so a long list can only be an attempt to exhaust
+// memory.
+const maxContentCodings = 4
+
+// decodeBody returns a reader which undoes the given content-codings, listed
+// in the order they were applied, on body. It returns false if there are more
+// than maxContentCodings of them, or any of them isn't in pools.
+func decodeBody(body io.ReadCloser, codings []string, pools map[string]*decoderPool) (io.ReadCloser, bool) {
+	if len(codings) > maxContentCodings {
+		return nil, false
+	}
+	// Codings are undone in reverse.
+	for i := len(codings) - 1; i >= 0; i-- {
+		pool, ok := pools[codings[i]]
+		if !ok {
+			return nil, false
+		}
+		body = &decodingReader{src: body, pool: pool}
+	}
+	return body, true
+}
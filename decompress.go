@@ -0,0 +1,321 @@
+package gziphandler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultMaxDecompressedSize is the default limit on the size of a
+	// request body after decompression. See MaxDecompressedSize.
+	DefaultMaxDecompressedSize = 32 << 20
+
+	// minRatioCheckSize is the number of decompressed bytes after which the
+	// limit set with MaxDecompressionRatio is enforced. Small bodies may
+	// legitimately compress very well, and can't do much harm anyway.
+	minRatioCheckSize = 1 << 20
+)
+
+// DecompressionLimitError is the error returned when reading the body of a
+// request decompressed by DecompressHandlerWithOpts, once one of its limits is
+// exceeded. Handlers can detect it with errors.As, to tell a malicious or
+// oversized body apart from a broken connection.
+type DecompressionLimitError struct {
+	// Limit is the exceeded limit: "size", "ratio" or "timeout".
+	Limit string
+
+	// Compressed is the number of bytes read from the request body, and
+	// Decompressed the number of bytes they decompressed to, when the limit
+	// was exceeded.
+	Compressed, Decompressed int64
+}
+
+func (e *DecompressionLimitError) Error() string {
+	return fmt.Sprintf("gziphandler: request body exceeds decompression %s limit (%d bytes decompressed from %d)", e.Limit, e.Decompressed, e.Compressed)
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.r.Close()
+}
+
+// limitReader enforces the decompression limits on a decoded request body.
+// Once a limit is exceeded, every Read returns a *DecompressionLimitError.
+type limitReader struct {
+	io.ReadCloser // The decoded body.
+	compressed    *countingReader
+	decompressed  int64
+
+	maxSize  int64
+	maxRatio float64
+	deadline time.Time
+
+	err *DecompressionLimitError
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if !r.deadline.IsZero() && time.Now().After(r.deadline) {
+		return 0, r.exceeded("timeout")
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.decompressed += int64(n)
+
+	switch {
+	case r.maxSize > 0 && r.decompressed > r.maxSize:
+		// Return what fits, like http.MaxBytesReader does.
+		n -= int(r.decompressed - r.maxSize)
+		r.decompressed = r.maxSize
+		return n, r.exceeded("size")
+	case r.maxRatio > 0 && r.decompressed > minRatioCheckSize && float64(r.decompressed) > r.maxRatio*float64(r.compressed.n):
+		return n, r.exceeded("ratio")
+	case !r.deadline.IsZero() && (errors.Is(err, os.ErrDeadlineExceeded) || time.Now().After(r.deadline)):
+		return n, r.exceeded("timeout")
+	}
+	return n, err
+}
+
+func (r *limitReader) exceeded(limit string) error {
+	r.err = &DecompressionLimitError{
+		Limit:        limit,
+		Compressed:   r.compressed.n,
+		Decompressed: r.decompressed,
+	}
+	return r.err
+}
+
+// limitResponseWriter makes sure that the response to a request whose body
+// exceeded a decompression limit has the status 413 Request Entity Too Large,
+// whatever the handler does about the error.
+type limitResponseWriter struct {
+	http.ResponseWriter
+	body        *limitReader
+	wroteHeader bool
+}
+
+// WriteHeader sends informational (1xx) responses other than 101 Switching
+// Protocols right away, since they precede the final response.
+func (w *limitResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	if w.body.err != nil {
+		code = http.StatusRequestEntityTooLarge
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (w *limitResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if fw, ok := w.ResponseWriter.(http.Flusher); ok {
+		fw.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *limitResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DecompressHandler wraps an HTTP handler, to transparently decompress the
+// request body if the client compressed it (via the Content-Encoding header)
+// using any of the built-in content-codings.
+func DecompressHandler(h http.Handler) http.Handler {
+	wrapper, _ := DecompressHandlerWithOpts()
+	return wrapper(h)
+}
+
+// DecompressHandlerWithOpts returns a wrapper function (often known as
+// middleware) which can be used to wrap an HTTP handler to transparently
+// decompress request bodies. Content-codings other than the built-in ones can
+// be added with Decoders.
+//
+// The body of a request with a Content-Encoding header is replaced with one
+// which decodes it, and the Content-Encoding and Content-Length headers are
+// removed, so the wrapped handler sees the request as if it was sent
+// uncompressed. Requests using a content-coding which can't be decoded, or
+// more than four stacked content-codings, are answered with 415 Unsupported
+// Media Type, listing the supported content-codings in an Accept-Encoding
+// header as RFC 9110 suggests.
+//
+// To protect against decompression bombs, decompressed bodies are limited to
+// DefaultMaxDecompressedSize bytes unless MaxDecompressedSize says otherwise;
+// see also MaxDecompressionRatio and DecompressTimeout. When a limit is
+// exceeded, reading the body fails with a *DecompressionLimitError, and the
+// response status is 413 Request Entity Too Large. zstd frames declaring a
+// window larger than RFC 9659 allows, or than MaxDecompressedSize, fail to
+// decode, since the window would be allocated before any limit applies.
+func DecompressHandlerWithOpts(opts ...option) (func(http.Handler) http.Handler, error) {
+	c, err := newConfig(forDecompress, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(h http.Handler) http.Handler {
+		pools := decoderPoolsByName(c.decoderPools())
+
+		names := make([]string, 0, len(pools))
+		for name := range pools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		supported := strings.Join(names, ", ")
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			codings := contentCodings(r.Header)
+			if len(codings) == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			compressed := &countingReader{r: r.Body}
+			body, ok := decodeBody(compressed, codings, pools)
+			if !ok {
+				w.Header().Set(acceptEncoding, supported)
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Header = r.Header.Clone()
+			r2.Header.Del(contentEncoding)
+			r2.Header.Del(contentLength)
+			if r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r2)
+				return
+			}
+
+			lr := &limitReader{
+				ReadCloser: body,
+				compressed: compressed,
+				maxSize:    c.maxDecompressedSize,
+				maxRatio:   c.maxDecompressionRatio,
+			}
+			defer lr.Close()
+			if c.decompressTimeout > 0 {
+				now := time.Now()
+				lr.deadline = now.Add(c.decompressTimeout)
+				// Also set a deadline on the connection, if possible, so
+				// that a Read blocked on a slow client is interrupted, unless
+				// the server's own comes first. The server's is restored
+				// afterwards, before closing the body drains what's left.
+				prev := serverReadDeadline(r, now)
+				if prev.IsZero() || lr.deadline.Before(prev) {
+					rc := http.NewResponseController(w)
+					if rc.SetReadDeadline(lr.deadline) == nil {
+						defer rc.SetReadDeadline(prev)
+					}
+				}
+			}
+
+			r2.Body = lr
+			r2.ContentLength = -1
+
+			lw := &limitResponseWriter{ResponseWriter: w, body: lr}
+			h.ServeHTTP(lw, r2)
+
+			if !lw.wroteHeader && lr.err != nil {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			}
+		})
+	}, nil
+}
+
+// serverReadDeadline returns the deadline the server set on the connection
+// for reading r, or zero if it has no ReadTimeout. net/http doesn't say when
+// it started reading the request, so the ReadTimeout is counted from now,
+// which leaves the client slightly more time than the server would.
+func serverReadDeadline(r *http.Request, now time.Time) time.Time {
+	srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok || srv.ReadTimeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(srv.ReadTimeout)
+}
+
+// MaxDecompressedSize limits the size of request bodies decompressed by
+// DecompressHandlerWithOpts. A size of zero or less disables the limit.
+func MaxDecompressedSize(size int64) option {
+	return scoped("MaxDecompressedSize", forDecompress, func(c *config) {
+		c.maxDecompressedSize = size
+	})
+}
+
+// MaxDecompressionRatio limits the ratio between the decompressed and the
+// compressed size of request bodies decompressed by DecompressHandlerWithOpts.
+// Ordinary payloads rarely compress better than 20:1, while decompression
+// bombs aim for 1000:1 and more. To avoid rejecting small bodies which happen
+// to compress very well, the limit is only enforced once 1MB has been
+// decompressed. Zero, the default, disables the limit.
+func MaxDecompressionRatio(ratio float64) option {
+	return scoped("MaxDecompressionRatio", forDecompress, func(c *config) {
+		c.maxDecompressionRatio = ratio
+	})
+}
+
+// DecompressTimeout limits the time the handler wrapped by
+// DecompressHandlerWithOpts has to read the decompressed request body,
+// starting when the request is received by the wrapper. Zero, the default,
+// disables the limit.
+func DecompressTimeout(d time.Duration) option {
+	return scoped("DecompressTimeout", forDecompress, func(c *config) {
+		c.decompressTimeout = d
+	})
+}
+
+// contentCodings returns the lower-cased content-codings listed in the
+// Content-Encoding header of a request or response, in the order they were
+// applied, skipping identity.
+func contentCodings(h http.Header) []string {
+	var codings []string
+	for _, v := range h.Values(contentEncoding) {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case "", "identity":
+				continue
+			case "x-gzip":
+				// RFC 9110 asks recipients to treat x-gzip as gzip.
+				coding = "gzip"
+			}
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
@@ -0,0 +1,500 @@
+package gziphandler
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecompressHandler(t *testing.T) {
+	tests := []struct {
+		contentEncoding string
+		body            []byte
+	}{
+		{"gzip", gzipStrLevel(testBody, gzip.DefaultCompression)},
+		{"x-gzip", gzipStrLevel(testBody, gzip.DefaultCompression)},
+		{"GZIP", gzipStrLevel(testBody, gzip.DefaultCompression)},
+		{"deflate", deflateStrLevel(testBody, gzip.DefaultCompression)},
+		{"br", brotliStr(testBody)},
+		{"zstd", zstdStr(testBody)},
+		{"identity", []byte(testBody)},
+		{"gzip, br", brotliStr(string(gzipStrLevel(testBody, gzip.DefaultCompression)))},
+	}
+
+	for _, tt := range tests {
+		// Run each twice so that pooled Decoders are reused.
+		for i := 0; i < 2; i++ {
+			var (
+				body            []byte
+				contentEncoding string
+				contentLength   int64
+			)
+			handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				contentEncoding = r.Header.Get("Content-Encoding")
+				contentLength = r.ContentLength
+			}))
+
+			req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(tt.body))
+			req.Header.Set("Content-Encoding", tt.contentEncoding)
+			req.Header.Set("Content-Length", strconv.Itoa(len(tt.body)))
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, 200, resp.Code, tt.contentEncoding)
+			assert.Equal(t, testBody, string(body), tt.contentEncoding)
+			if tt.contentEncoding != "identity" {
+				assert.Equal(t, "", contentEncoding, tt.contentEncoding)
+				assert.Equal(t, int64(-1), contentLength, tt.contentEncoding)
+			}
+		}
+	}
+}
+
+func TestDecompressHandlerUncompressed(t *testing.T) {
+	var body []byte
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		assert.Equal(t, int64(len(testBody)), r.ContentLength)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewBufferString(testBody))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, testBody, string(body))
+}
+
+func TestDecompressHandlerUnsupported(t *testing.T) {
+	called := false
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewBufferString(testBody))
+	req.Header.Set("Content-Encoding", "compress")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	assert.Equal(t, "br, deflate, gzip, zstd", resp.Header().Get("Accept-Encoding"))
+}
+
+func TestDecompressHandlerStackedCodings(t *testing.T) {
+	var body []byte
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+
+	compressed := []byte(testBody)
+	for i := 0; i < 4; i++ {
+		compressed = gzipStrLevel(string(compressed), gzip.BestSpeed)
+	}
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(compressed))
+	req.Header.Set("Content-Encoding", "gzip, gzip, gzip, gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, 200, resp.Code)
+	assert.Equal(t, testBody, string(body))
+
+	body = nil
+	req = httptest.NewRequest("POST", "/whatever", bytes.NewReader(gzipStrLevel(string(compressed), gzip.BestSpeed)))
+	req.Header.Set("Content-Encoding", "gzip, gzip, gzip, gzip, gzip")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Nil(t, body)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+}
+
+func TestDecompressHandlerTooManyCodings(t *testing.T) {
+	var news int
+	called := false
+	wrapper, _ := DecompressHandlerWithOpts(Decoders(countingDecoderFactory{&news}))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		ioutil.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewBufferString("HELLO"))
+	req.Header.Set("Content-Encoding", strings.Repeat("x-upper, ", 10000))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	assert.Zero(t, news)
+}
+
+func TestDecompressHandlerZstdWindow(t *testing.T) {
+	tests := []struct {
+		opts      []option
+		windowLog uint
+		ok        bool
+	}{
+		{nil, 23, true},
+		{nil, 24, false},
+		{[]option{MaxDecompressedSize(1 << 20)}, 20, true},
+		{[]option{MaxDecompressedSize(1 << 20)}, 21, false},
+	}
+
+	for _, tt := range tests {
+		var (
+			body []byte
+			err  error
+		)
+		wrapper, _ := DecompressHandlerWithOpts(tt.opts...)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err = ioutil.ReadAll(r.Body)
+		}))
+
+		req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(zstdFrame("hello", tt.windowLog)))
+		req.Header.Set("Content-Encoding", "zstd")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if tt.ok {
+			assert.Nil(t, err, tt.windowLog)
+			assert.Equal(t, "hello", string(body), tt.windowLog)
+		} else {
+			assert.Error(t, err, tt.windowLog)
+			assert.Empty(t, body, tt.windowLog)
+		}
+	}
+}
+
+func TestDecompressTimeoutRestoresReadTimeout(t *testing.T) {
+	wrapper, _ := DecompressHandlerWithOpts(DecompressTimeout(time.Minute))
+	srv := httptest.NewUnstartedServer(wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	srv.Config.ReadTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	// Send none of the body. Closing it drains it, which must be limited by
+	// the server's ReadTimeout again rather than by DecompressTimeout.
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Encoding: gzip\r\nContent-Length: 100\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestDecompressHandlerCorrupt(t *testing.T) {
+	var err error
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err = ioutil.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewBufferString(testBody))
+	req.Header.Set("Content-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, gzip.ErrHeader, err)
+}
+
+func TestDecoders(t *testing.T) {
+	var body []byte
+	wrapper, err := DecompressHandlerWithOpts(Decoders(lowerDecoderFactory{}))
+	if !assert.Nil(t, err) {
+		return
+	}
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewBufferString("HELLO"))
+	req.Header.Set("Content-Encoding", "x-upper")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "hello", string(body))
+
+	_, err = DecompressHandlerWithOpts(Decoders(nil))
+	assert.Error(t, err)
+}
+
+// lowerDecoderFactory creates Decoders undoing upperEncoder, as far as
+// that's possible.
+type lowerDecoderFactory struct{}
+
+func (f lowerDecoderFactory) Name() string {
+	return "x-upper"
+}
+
+func (f lowerDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	return &lowerDecoder{r: r}, nil
+}
+
+type lowerDecoder struct {
+	r io.Reader
+}
+
+func (d *lowerDecoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	copy(p, bytes.ToLower(p[:n]))
+	return n, err
+}
+
+func (d *lowerDecoder) Reset(r io.Reader) error { d.r = r; return nil }
+func (d *lowerDecoder) Close() error            { return nil }
+
+// countingDecoderFactory is a lowerDecoderFactory which counts the Decoders
+// it creates.
+type countingDecoderFactory struct {
+	news *int
+}
+
+func (f countingDecoderFactory) Name() string {
+	return "x-upper"
+}
+
+func (f countingDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	*f.news++
+	return lowerDecoderFactory{}.NewDecoder(r)
+}
+
+// --------------------------------------------------------------------
+
+func brotliStr(s string) []byte {
+	var b bytes.Buffer
+	w := brotli.NewWriter(&b)
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
+
+func zstdStr(s string) []byte {
+	var b bytes.Buffer
+	w, _ := zstd.NewWriter(&b)
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
+
+// zstdFrame returns a zstd frame holding s in a single raw block, which
+// declares a window of 1<<windowLog bytes. Encoders shrink the window to fit
+// small contents, so this is what a malicious client would send.
+func zstdFrame(s string, windowLog uint) []byte {
+	b := []byte{0x28, 0xb5, 0x2f, 0xfd, 0, byte(windowLog-10) << 3}
+	header := len(s)<<3 | 1 // Raw, last block.
+	b = append(b, byte(header), byte(header>>8), byte(header>>16))
+	return append(b, s...)
+}
+
+func TestDecompressionLimits(t *testing.T) {
+	bomb := gzipStrLevel(string(make([]byte, 8<<20)), gzip.BestCompression)
+
+	tests := []struct {
+		name  string
+		opts  []option
+		limit string
+	}{
+		{"size", []option{MaxDecompressedSize(4 << 20)}, "size"},
+		{"ratio", []option{MaxDecompressionRatio(100)}, "ratio"},
+		{"size before ratio", []option{MaxDecompressedSize(1 << 20), MaxDecompressionRatio(100)}, "size"},
+	}
+
+	for _, tt := range tests {
+		var (
+			n   int64
+			err error
+		)
+		wrapper, werr := DecompressHandlerWithOpts(tt.opts...)
+		if !assert.Nil(t, werr, tt.name) {
+			continue
+		}
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n, err = io.Copy(ioutil.Discard, r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		}))
+
+		req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(bomb))
+		req.Header.Set("Content-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		var lerr *DecompressionLimitError
+		if assert.True(t, errors.As(err, &lerr), tt.name) {
+			assert.Equal(t, tt.limit, lerr.Limit, tt.name)
+			assert.Equal(t, n, lerr.Decompressed, tt.name)
+			assert.True(t, lerr.Compressed > 0 && lerr.Compressed <= int64(len(bomb)), tt.name)
+		}
+		if tt.limit == "size" {
+			assert.True(t, n <= 4<<20, tt.name)
+		}
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code, tt.name)
+	}
+}
+
+func TestDecompressHandlerEarlyHints(t *testing.T) {
+	var body []byte
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found")
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var informational []int
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "POST", server.URL,
+		bytes.NewReader(gzipStrLevel(testBody, gzip.DefaultCompression)))
+	req.Header.Set("Content-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	resBody, _ := ioutil.ReadAll(res.Body)
+
+	assert.Equal(t, testBody, string(body))
+	assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Equal(t, "not found", string(resBody))
+}
+
+func TestDecompressionLimitsDefault(t *testing.T) {
+	bomb := gzipStrLevel(string(make([]byte, DefaultMaxDecompressedSize+1)), gzip.BestCompression)
+
+	var n int64
+	handler := DecompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Ignore the error, the response must still be a 413.
+		n, _ = io.Copy(ioutil.Discard, r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, int64(DefaultMaxDecompressedSize), n)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+
+	// The limit can be disabled.
+	wrapper, _ := DecompressHandlerWithOpts(MaxDecompressedSize(0))
+	handler = wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ = io.Copy(ioutil.Discard, r.Body)
+	}))
+	req = httptest.NewRequest("POST", "/whatever", bytes.NewReader(bomb))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, int64(DefaultMaxDecompressedSize+1), n)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
+
+func TestDecompressionRatioAllowsSmallBodies(t *testing.T) {
+	var body []byte
+	wrapper, _ := DecompressHandlerWithOpts(MaxDecompressionRatio(2))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+
+	req := httptest.NewRequest("POST", "/whatever", bytes.NewReader(gzipStrLevel(testBody, gzip.DefaultCompression)))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, testBody, string(body))
+}
+
+func TestDecompressTimeout(t *testing.T) {
+	var err error
+	wrapper, _ := DecompressHandlerWithOpts(DecompressTimeout(10 * time.Millisecond))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err = io.Copy(ioutil.Discard, r.Body)
+	}))
+
+	body := &slowReader{r: bytes.NewReader(gzipStrLevel(testBody, gzip.DefaultCompression)), delay: 20 * time.Millisecond}
+	req := httptest.NewRequest("POST", "/whatever", body)
+	req.Header.Set("Content-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	var lerr *DecompressionLimitError
+	if assert.True(t, errors.As(err, &lerr)) {
+		assert.Equal(t, "timeout", lerr.Limit)
+	}
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
+
+func TestDecompressionLimitsMustBePositive(t *testing.T) {
+	_, err := DecompressHandlerWithOpts(MaxDecompressionRatio(-1))
+	assert.Error(t, err)
+	_, err = DecompressHandlerWithOpts(DecompressTimeout(-time.Second))
+	assert.Error(t, err)
+}
+
+// slowReader returns at most 16 bytes per Read, after a delay.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	return r.r.Read(p)
+}
+
+func TestDecompressTimeoutKeepAlive(t *testing.T) {
+	wrapper, _ := DecompressHandlerWithOpts(DecompressTimeout(50 * time.Millisecond))
+	srv := httptest.NewServer(wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})))
+	defer srv.Close()
+
+	// The connection deadline must not outlive the request, or it would
+	// break the next request on the same connection.
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", srv.URL, bytes.NewReader(gzipStrLevel(testBody, gzip.DefaultCompression)))
+		req.Header.Set("Content-Encoding", "gzip")
+		res, err := srv.Client().Do(req)
+		if !assert.Nil(t, err) {
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, testBody, string(body))
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,97 @@
+package gziphandler
+
+import (
+	"compress/zlib"
+	"io"
+	"sync"
+)
+
+// deflateWriterPools stores a sync.Pool for each compression level for reuse
+// of zlib.Writers. It's indexed with poolIndex, just like gzipWriterPools.
+var deflateWriterPools [zlib.BestCompression - zlib.BestSpeed + 2]*sync.Pool
+
+func init() {
+	for i := zlib.BestSpeed; i <= zlib.BestCompression; i++ {
+		addDeflateLevelPool(i)
+	}
+	addDeflateLevelPool(zlib.DefaultCompression)
+}
+
+// addDeflateLevelPool creates an empty pool for the given level, which like
+// those of addLevelPool has no New func.
+func addDeflateLevelPool(level int) {
+	deflateWriterPools[poolIndex(level)] = &sync.Pool{}
+}
+
+// deflateEncoderFactory is the built-in EncoderFactory for the deflate
+// content-coding, which despite its name is the zlib format (RFC 1950) rather
+// than raw deflate. See RFC 9110, section 8.4.1.2. Its Encoders are pooled in
+// deflateWriterPools.
+type deflateEncoderFactory struct {
+	level int
+}
+
+func (f deflateEncoderFactory) Name() string {
+	return "deflate"
+}
+
+func (f deflateEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	zw, err := zlib.NewWriterLevel(w, f.level)
+	if err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+func (f deflateEncoderFactory) pool() *sync.Pool {
+	return deflateWriterPools[poolIndex(f.level)]
+}
+
+func (f deflateEncoderFactory) compressionLevel() int {
+	return f.level
+}
+
+// deflateReaderPool stores zlibDecoders for reuse.
+var deflateReaderPool sync.Pool
+
+// deflateDecoderFactory is the built-in DecoderFactory for the deflate
+// content-coding. Its Decoders are pooled in deflateReaderPool.
+type deflateDecoderFactory struct{}
+
+func (f deflateDecoderFactory) Name() string {
+	return "deflate"
+}
+
+func (f deflateDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zlibDecoder{zr}, nil
+}
+
+func (f deflateDecoderFactory) pool() *sync.Pool {
+	return &deflateReaderPool
+}
+
+// zlibDecoder adapts the io.ReadCloser returned by zlib.NewReader to Decoder.
+type zlibDecoder struct {
+	io.ReadCloser
+}
+
+func (d zlibDecoder) Reset(r io.Reader) error {
+	return d.ReadCloser.(zlib.Resetter).Reset(r, nil)
+}
+
+// DeflateLevel enables the deflate content-coding, compressing at the given
+// level. Valid levels are the same as for CompressionLevel.
+//
+// deflate is preferred less than every other content-coding, so it's only
+// used when a client accepts it with a higher qvalue, or doesn't accept the
+// others at all, unless PreferredEncodings says otherwise.
+func DeflateLevel(level int) option {
+	return scoped("DeflateLevel", compressing|forTransport, func(c *config) {
+		c.deflate = true
+		c.deflateLevel = level
+	})
+}
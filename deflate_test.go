@@ -0,0 +1,86 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeflateLevel(t *testing.T) {
+	for lvl := zlib.BestSpeed; lvl <= zlib.BestCompression; lvl++ {
+		wrapper, err := GzipHandlerWithOpts(DeflateLevel(lvl))
+		if !assert.Nil(t, err, "GzipHandlerWithOpts returned error for deflate level:", lvl) {
+			continue
+		}
+
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "deflate")
+		resp := httptest.NewRecorder()
+		wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody)
+		})).ServeHTTP(resp, req)
+		res := resp.Result()
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+		assert.Equal(t, deflateStrLevel(testBody, lvl), resp.Body.Bytes())
+	}
+}
+
+func TestDeflateLevelReturnsErrorForInvalidLevels(t *testing.T) {
+	for _, lvl := range []int{-42, zlib.NoCompression, zlib.HuffmanOnly, 10} {
+		_, err := GzipHandlerWithOpts(DeflateLevel(lvl))
+		assert.NotNil(t, err, "Expected error for invalid deflate level:", lvl)
+	}
+}
+
+func TestDeflateNegotiation(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(DeflateLevel(gzip.DefaultCompression))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	tests := map[string]string{
+		"deflate":                   "deflate",
+		"gzip, deflate":             "gzip",
+		"deflate, gzip":             "gzip",
+		"gzip;q=0.5, deflate":       "deflate",
+		"gzip;q=0, deflate;q=0.1":   "deflate",
+		"gzip, deflate;q=0":         "gzip",
+		"compress, deflate;q=0.001": "deflate",
+	}
+
+	for ae, expected := range tests {
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, expected, resp.Header().Get("Content-Encoding"), ae)
+		if expected == "deflate" {
+			zr, err := zlib.NewReader(resp.Body)
+			if assert.Nil(t, err) {
+				body, _ := ioutil.ReadAll(zr)
+				assert.Equal(t, testBody, string(body))
+			}
+		}
+	}
+}
+
+// --------------------------------------------------------------------
+
+func deflateStrLevel(s string, lvl int) []byte {
+	var b bytes.Buffer
+	w, _ := zlib.NewWriterLevel(&b, lvl)
+	io.WriteString(w, s)
+	w.Close()
+	return b.Bytes()
+}
@@ -0,0 +1,67 @@
+package gziphandler
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// EncoderError is the error passed to the ErrorHandler, and returned by the
+// GzipResponseWriter, when the Encoder of a response fails to write, flush or
+// close, usually because the connection to the client failed. Tests and
+// ErrorHandlers can detect it with errors.As.
+type EncoderError struct {
+	// Op is the failed operation: "write", "flush" or "close".
+	Op string
+
+	// Encoding is the content-coding of the response.
+	Encoding string
+
+	Err error
+}
+
+func (e *EncoderError) Error() string {
+	return fmt.Sprintf("gziphandler: %s encoder failed to %s: %v", e.Encoding, e.Op, e.Err)
+}
+
+func (e *EncoderError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorHandler sets the function called when the Encoder of a response fails,
+// with the underlying ResponseWriter, the request and an *EncoderError. It's
+// called at most once per response, after the failure is logged.
+//
+// The response is compressed as it's written, so by then the header, and
+// part of the body, may have been sent, and the client would take what's
+// left for a complete, if truncated, response. So by default the handler
+// panics with http.ErrAbortHandler, which makes net/http abort the
+// response, and reset the connection, without logging a stack trace. If
+// handler returns instead, the failure is returned by the Write, or Close,
+// of the GzipResponseWriter.
+func ErrorHandler(handler func(w http.ResponseWriter, r *http.Request, err error)) option {
+	return scoped("ErrorHandler", compressing, func(c *config) {
+		c.errorHandler = handler
+	})
+}
+
+// abortResponse is the default ErrorHandler.
+func abortResponse(http.ResponseWriter, *http.Request, error) {
+	panic(http.ErrAbortHandler)
+}
+
+// encoderFailed reports err, the failure of op on the Encoder, to the
+// ErrorHandler if it's the first, and returns it as an *EncoderError.
+func (w *GzipResponseWriter) encoderFailed(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if w.encoderErr != nil {
+		return w.encoderErr
+	}
+	w.encoderErr = &EncoderError{Op: op, Encoding: w.pool.name, Err: err}
+	w.logError(op, w.encoderErr)
+	if w.onError != nil {
+		w.onError(w.ResponseWriter, w.decision.Request, w.encoderErr)
+	}
+	return w.encoderErr
+}
@@ -0,0 +1,140 @@
+package gziphandler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// panics returns the value f panics with, if any.
+func panics(f func()) (v any) {
+	defer func() {
+		v = recover()
+	}()
+	f()
+	return nil
+}
+
+func TestEncoderFailureAborts(t *testing.T) {
+	var decisions []Decision
+	wrapper, _ := GzipHandlerWithOpts(Observer(func(d Decision) {
+		decisions = append(decisions, d)
+	}))
+	var writeErr error
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, writeErr = io.WriteString(w, testBody)
+	}))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	assert.Equal(t, http.ErrAbortHandler, panics(func() {
+		handler.ServeHTTP(&failingResponseWriter{httptest.NewRecorder(), 0}, req)
+	}))
+	assert.Nil(t, writeErr)
+	if assert.Len(t, decisions, 1) {
+		assert.Equal(t, ReasonCompressed, decisions[0].Reason)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		limit  int
+		flush  bool
+		op     string
+		writes int
+	}{
+		{"write", 0, false, "write", 2},
+		{"flush", 10, true, "flush", 1},
+		{"close", 10, false, "close", 1},
+	} {
+		var errs []error
+		wrapper, _ := GzipHandlerWithOpts(ErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			assert.Equal(t, "/path", r.URL.Path, tt.name)
+			_, ok := w.(*failingResponseWriter)
+			assert.True(t, ok, tt.name)
+			errs = append(errs, err)
+		}))
+		var writeErrs []error
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < tt.writes; i++ {
+				_, err := io.WriteString(w, testBody)
+				writeErrs = append(writeErrs, err)
+			}
+			if tt.flush {
+				w.(http.Flusher).Flush()
+			}
+		}))
+
+		req, _ := http.NewRequest("GET", "/path", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		assert.Nil(t, panics(func() {
+			handler.ServeHTTP(&failingResponseWriter{httptest.NewRecorder(), tt.limit}, req)
+		}), tt.name)
+
+		// The handler is only told about the first failure.
+		if !assert.Len(t, errs, 1, tt.name) {
+			continue
+		}
+		var ee *EncoderError
+		if assert.True(t, errors.As(errs[0], &ee), tt.name) {
+			assert.Equal(t, tt.op, ee.Op, tt.name)
+			assert.Equal(t, "gzip", ee.Encoding, tt.name)
+		}
+		assert.True(t, errors.Is(errs[0], errBrokenPipe), tt.name)
+		assert.Equal(t, "gziphandler: gzip encoder failed to "+tt.op+": broken pipe", errs[0].Error(), tt.name)
+
+		if tt.op == "write" {
+			// Later writes return the same error.
+			assert.Equal(t, []error{errs[0], errs[0]}, writeErrs, tt.name)
+		}
+	}
+
+	_, err := GzipHandlerWithOpts(ErrorHandler(nil))
+	assert.Error(t, err)
+}
+
+func TestEncoderFailureTruncatesResponse(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(Encoders(failingEncoderFactory{}))
+	srv := httptest.NewServer(wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+		w.(http.Flusher).Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "failing")
+	res, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "failing", res.Header.Get(contentEncoding))
+
+	// The client sees the response end abruptly, rather than complete.
+	body, err := io.ReadAll(res.Body)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, testBody, string(body))
+}
+
+// failingEncoderFactory creates Encoders which pass writes through, but fail
+// to close.
+type failingEncoderFactory struct{}
+
+func (failingEncoderFactory) Name() string { return "failing" }
+
+func (failingEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	return &failingEncoder{w}, nil
+}
+
+type failingEncoder struct {
+	io.Writer
+}
+
+func (e *failingEncoder) Flush() error      { return nil }
+func (e *failingEncoder) Close() error      { return errors.New("encoder failed") }
+func (e *failingEncoder) Reset(w io.Writer) { e.Writer = w }
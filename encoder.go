@@ -0,0 +1,152 @@
+package gziphandler
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Encoder compresses the bytes written to it and writes the result to an
+// underlying io.Writer. Encoders are pooled and reused across responses, so
+// Reset must discard any previous state and start a new stream writing to w.
+//
+// *gzip.Writer, *zlib.Writer and *flate.Writer all satisfy this interface.
+type Encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+// EncoderFactory creates Encoders for a single content-coding.
+type EncoderFactory interface {
+	// Name returns the content-coding produced by the Encoders, as it appears
+	// in the Accept-Encoding and Content-Encoding headers, e.g. "gzip".
+	Name() string
+
+	// NewEncoder returns a new Encoder which writes to w.
+	NewEncoder(w io.Writer) (Encoder, error)
+}
+
+// pooledFactory is implemented by the built-in EncoderFactories and
+// DecoderFactories, which share package level pools between handlers (see
+// gzipWriterPools).
+type pooledFactory interface {
+	pool() *sync.Pool
+}
+
+// encoderPool stores the Encoders created by a single EncoderFactory for reuse.
+type encoderPool struct {
+	name    string // Lower-cased factory.Name().
+	factory EncoderFactory
+	pool    *sync.Pool
+}
+
+func newEncoderPool(f EncoderFactory) *encoderPool {
+	p := &encoderPool{
+		name:    strings.ToLower(f.Name()),
+		factory: f,
+	}
+	if pf, ok := f.(pooledFactory); ok {
+		p.pool = pf.pool()
+	} else {
+		p.pool = &sync.Pool{}
+	}
+	return p
+}
+
+// get returns an Encoder writing to w, reusing a pooled one if possible, and
+// whether it did.
+func (p *encoderPool) get(w io.Writer) (Encoder, bool, error) {
+	if e, ok := p.pool.Get().(Encoder); ok {
+		e.Reset(w)
+		return e, true, nil
+	}
+	e, err := p.factory.NewEncoder(w)
+	return e, false, err
+}
+
+// put returns an Encoder obtained from get to the pool.
+func (p *encoderPool) put(e Encoder) {
+	p.pool.Put(e)
+}
+
+// Encoders registers additional content-codings with the handler. Each
+// request's Accept-Encoding header is negotiated against the registered
+// encoders and the built-in ones, and the response is compressed with the one
+// the client accepts with the highest qvalue. Ties are broken in favour of
+// the encoder registered first, with the built-in encoders coming last,
+// unless PreferredEncodings says otherwise.
+//
+// A factory whose Name is that of a built-in encoder, e.g. "gzip", replaces
+// it, in which case the level options for that encoder have no effect. The
+// names "identity" and "*" are reserved, and rejected.
+func Encoders(factories ...EncoderFactory) option {
+	return scoped("Encoders", compressing|forTransport, func(c *config) {
+		c.encoders = append(c.encoders, factories...)
+	})
+}
+
+// PreferredEncodings sets the order in which content-codings are preferred
+// when a client accepts several of them with the same qvalue. Content-codings
+// which are not listed are preferred less than listed ones, and keep their
+// default order relative to each other.
+func PreferredEncodings(names ...string) option {
+	return scoped("PreferredEncodings", compressing, func(c *config) {
+		c.preferredEncodings = names
+	})
+}
+
+// encoderPools returns the pools for every content-coding the handler can
+// produce, in order of preference.
+func (c *config) encoderPools() []*encoderPool {
+	factories := make([]EncoderFactory, 0, len(c.encoders)+4)
+	factories = append(factories, c.encoders...)
+	if c.zstd {
+		factories = append(factories, zstdEncoderFactory{level: c.zstdLevel, windowSize: c.zstdWindowSize})
+	}
+	if c.brotli {
+		factories = append(factories, brotliEncoderFactory{quality: c.brotliLevel})
+	}
+	factories = append(factories, gzipEncoderFactory{level: c.level})
+	if c.deflate {
+		factories = append(factories, deflateEncoderFactory{level: c.deflateLevel})
+	}
+
+	pools := make([]*encoderPool, 0, len(factories))
+	seen := make(map[string]bool, len(factories))
+	for _, f := range factories {
+		p := newEncoderPool(f)
+		if seen[p.name] {
+			continue
+		}
+		seen[p.name] = true
+		pools = append(pools, p)
+	}
+
+	less := c.preferenceLess()
+	sort.SliceStable(pools, func(i, j int) bool {
+		return less(pools[i].name, pools[j].name)
+	})
+	return pools
+}
+
+// preferenceLess returns a function reporting whether the content-coding a
+// is preferred over b according to PreferredEncodings. Both names must be
+// lower-case.
+func (c *config) preferenceLess() func(a, b string) bool {
+	rank := make(map[string]int, len(c.preferredEncodings))
+	for i, name := range c.preferredEncodings {
+		if _, ok := rank[strings.ToLower(name)]; !ok {
+			rank[strings.ToLower(name)] = i
+		}
+	}
+	return func(a, b string) bool {
+		ra, aok := rank[a]
+		rb, bok := rank[b]
+		if aok && bok {
+			return ra < rb
+		}
+		return aok && !bok
+	}
+}
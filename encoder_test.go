@@ -0,0 +1,139 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// upperEncoder is a toy Encoder which upper-cases everything written to it.
+type upperEncoder struct {
+	w io.Writer
+}
+
+func (e *upperEncoder) Write(b []byte) (int, error) {
+	return e.w.Write(bytes.ToUpper(b))
+}
+
+func (e *upperEncoder) Reset(w io.Writer) { e.w = w }
+func (e *upperEncoder) Flush() error      { return nil }
+func (e *upperEncoder) Close() error      { return nil }
+
+type upperEncoderFactory struct{}
+
+func (f upperEncoderFactory) Name() string {
+	return "x-upper"
+}
+
+func (f upperEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	return &upperEncoder{w: w}, nil
+}
+
+func TestEncoders(t *testing.T) {
+	wrapper, err := GzipHandlerWithOpts(Encoders(upperEncoderFactory{}))
+	assert.Nil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	tests := []struct {
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{"x-upper", "x-upper"},
+		{"X-Upper", "x-upper"},
+		{"gzip", "gzip"},
+		{"gzip, x-upper", "x-upper"},
+		{"gzip, x-upper;q=0.5", "gzip"},
+		{"x-upper;q=0", ""},
+		{"br", ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.acceptEncoding)
+		switch tt.expectedEncoding {
+		case "x-upper":
+			assert.Equal(t, string(bytes.ToUpper([]byte(testBody))), resp.Body.String())
+		case "gzip":
+			assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), resp.Body.Bytes())
+		default:
+			assert.Equal(t, testBody, resp.Body.String())
+		}
+	}
+}
+
+func TestEncoderPool(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items at random under the race detector")
+	}
+	p := newEncoderPool(upperEncoderFactory{})
+
+	var first, second bytes.Buffer
+	e, reused, err := p.get(&first)
+	assert.Nil(t, err)
+	assert.False(t, reused)
+	io.WriteString(e, "first")
+	p.put(e)
+
+	// Encoders are returned to the pool, and reset when reused.
+	e2, reused, err := p.get(&second)
+	assert.Nil(t, err)
+	assert.True(t, reused)
+	assert.True(t, e == e2)
+	io.WriteString(e2, "second")
+	assert.Equal(t, "FIRST", first.String())
+	assert.Equal(t, "SECOND", second.String())
+}
+
+func TestEncodersReplaceGzip(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(Encoders(namedEncoderFactory{"gzip"}))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, string(bytes.ToUpper([]byte(testBody))), resp.Body.String())
+}
+
+func TestEncodersMustBeNamed(t *testing.T) {
+	_, err := GzipHandlerWithOpts(Encoders(namedEncoderFactory{""}))
+	assert.Error(t, err)
+	_, err = GzipHandlerWithOpts(Encoders(nil))
+	assert.Error(t, err)
+
+	// Neither can be sent as a Content-Encoding.
+	_, err = GzipHandlerWithOpts(Encoders(namedEncoderFactory{"identity"}))
+	assert.Error(t, err)
+	_, err = GzipHandlerWithOpts(Encoders(namedEncoderFactory{"*"}))
+	assert.Error(t, err)
+	_, err = GzipHandlerWithOpts(Encoders(namedEncoderFactory{"Identity"}))
+	assert.Error(t, err)
+}
+
+// namedEncoderFactory creates upperEncoders under an arbitrary name.
+type namedEncoderFactory struct {
+	name string
+}
+
+func (f namedEncoderFactory) Name() string {
+	return f.name
+}
+
+func (f namedEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	return &upperEncoder{w: w}, nil
+}
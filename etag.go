@@ -0,0 +1,153 @@
+package gziphandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	ifMatch     = "If-Match"
+	ifNoneMatch = "If-None-Match"
+)
+
+// ETagMode says how the handler rewrites the ETag header of the responses it
+// compresses. A compressed response is a different representation of the
+// resource from the uncompressed one, so it mustn't carry the same strong
+// ETag, or caches could mix up their bytes.
+type ETagMode int
+
+const (
+	// ETagKeep leaves ETags alone. This is the default.
+	ETagKeep ETagMode = iota
+
+	// ETagWeaken turns strong ETags into weak ones, e.g. `"abc"` into
+	// `W/"abc"`, which only promise semantic equivalence. Weak ETags still
+	// work with If-None-Match, but never match If-Match or If-Range.
+	ETagWeaken
+
+	// ETagSuffix appends the content-coding to ETags, e.g. `"abc"` becomes
+	// `"abc-gzip"`. The suffix of the negotiated content-coding is removed
+	// from the ETags in If-None-Match and If-Match headers before the handler
+	// sees them, so conditional requests keep working. ETags with the suffix
+	// of another content-coding are left alone, so they don't match.
+	ETagSuffix
+)
+
+// ETags sets how the handler rewrites the ETags of compressed responses.
+func ETags(mode ETagMode) option {
+	return scoped("ETags", compressing, func(c *config) {
+		c.etagMode = mode
+	})
+}
+
+// rewriteETag rewrites the ETag header of the response as configured, for
+// the negotiated content-coding.
+func (w *GzipResponseWriter) rewriteETag() {
+	tag := w.Header().Get(etag)
+	if tag == "" {
+		return
+	}
+	switch w.etagMode {
+	case ETagWeaken:
+		if !strings.HasPrefix(tag, "W/") {
+			w.Header().Set(etag, "W/"+tag)
+		}
+	case ETagSuffix:
+		if strings.HasSuffix(tag, `"`) && len(tag) > 1 {
+			w.Header().Set(etag, tag[:len(tag)-1]+"-"+w.pool.name+`"`)
+		}
+	}
+}
+
+// conditionalETags prepares r for the handler, according to mode. It returns
+// r, or a shallow copy of it whose If-None-Match and If-Match headers are
+// stripped of the suffix added by ETagSuffix for the negotiated pool, and
+// whether its If-None-Match header refers to a compressed representation, in
+// which case a 304 response must carry a rewritten ETag. pool is nil if the
+// response won't be compressed, in which case no suffix is stripped.
+func conditionalETags(r *http.Request, mode ETagMode, pool *encoderPool) (*http.Request, bool) {
+	if mode == ETagWeaken {
+		return r, strings.Contains(strings.Join(r.Header.Values(ifNoneMatch), ","), "W/")
+	}
+	if mode != ETagSuffix || pool == nil {
+		return r, false
+	}
+
+	var (
+		h          http.Header
+		compressed bool
+	)
+	for _, name := range []string{ifNoneMatch, ifMatch} {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if h == nil {
+			h = r.Header.Clone()
+		}
+		h.Del(name)
+		for _, v := range values {
+			u, suffixed := unsuffixETagList(v, pool.name)
+			if name == ifNoneMatch && suffixed {
+				compressed = true
+			}
+			h.Add(name, u)
+		}
+	}
+	if h == nil {
+		return r, false
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = h
+	return r2, compressed
+}
+
+// unsuffixETagList strips the suffix for the named content-coding from the
+// entity-tags in s, a comma-separated list as found in If-None-Match and
+// If-Match headers, and reports whether there were any. Anything it can't
+// parse is left as it is.
+func unsuffixETagList(s, coding string) (string, bool) {
+	var (
+		b        strings.Builder
+		suffixed bool
+	)
+	for rest := s; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return b.String(), suffixed
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+
+		// Copy "*" and anything which isn't an entity-tag until the next comma.
+		tag := rest
+		if strings.HasPrefix(tag, "W/") {
+			tag = tag[2:]
+		}
+		end := -1
+		if strings.HasPrefix(tag, `"`) {
+			end = strings.IndexByte(tag[1:], '"')
+		}
+		if end < 0 {
+			i := strings.IndexByte(rest, ',')
+			if i < 0 {
+				i = len(rest)
+			}
+			b.WriteString(strings.TrimSpace(rest[:i]))
+			rest = rest[i:]
+			continue
+		}
+
+		prefix := rest[:len(rest)-len(tag)]
+		opaque := tag[1 : end+1]
+		if trimmed := strings.TrimSuffix(opaque, "-"+coding); trimmed != opaque {
+			opaque = trimmed
+			suffixed = true
+		}
+		b.WriteString(prefix + `"` + opaque + `"`)
+		rest = tag[end+2:]
+	}
+}
@@ -0,0 +1,136 @@
+package gziphandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETags(t *testing.T) {
+	tests := []struct {
+		mode           ETagMode
+		etag           string
+		acceptEncoding string
+		expectedETag   string
+	}{
+		{ETagKeep, `"abc"`, "gzip", `"abc"`},
+		{ETagWeaken, `"abc"`, "gzip", `W/"abc"`},
+		{ETagWeaken, `W/"abc"`, "gzip", `W/"abc"`},
+		{ETagWeaken, `"abc"`, "identity", `"abc"`},
+		{ETagSuffix, `"abc"`, "gzip", `"abc-gzip"`},
+		{ETagSuffix, `W/"abc"`, "gzip", `W/"abc-gzip"`},
+		{ETagSuffix, `"abc"`, "br", `"abc-br"`},
+		{ETagSuffix, `"abc"`, "identity", `"abc"`},
+	}
+
+	for _, tt := range tests {
+		wrapper, err := GzipHandlerWithOpts(ETags(tt.mode), BrotliLevel(4))
+		assert.Nil(t, err)
+		handler := wrapper(newETagHandler(tt.etag))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedETag, resp.Header().Get("Etag"), tt.etag, tt.acceptEncoding)
+	}
+
+	_, err := GzipHandlerWithOpts(ETags(ETagMode(42)))
+	assert.Error(t, err)
+}
+
+func TestETagsConditionalRequests(t *testing.T) {
+	tests := []struct {
+		mode         ETagMode
+		header       string
+		value        string
+		expectedCode int
+		expectedETag string
+	}{
+		{ETagSuffix, "If-None-Match", `"abc-gzip"`, http.StatusNotModified, `"abc-gzip"`},
+		{ETagSuffix, "If-None-Match", `"xyz", W/"abc-gzip"`, http.StatusNotModified, `"abc-gzip"`},
+		{ETagSuffix, "If-None-Match", `"abc-br"`, http.StatusOK, `"abc-gzip"`},
+		{ETagSuffix, "If-None-Match", `"abc"`, http.StatusNotModified, `"abc"`},
+		{ETagSuffix, "If-None-Match", `"xyz-gzip"`, http.StatusOK, `"abc-gzip"`},
+		{ETagSuffix, "If-None-Match", `*`, http.StatusNotModified, `"abc"`},
+		{ETagSuffix, "If-Match", `"abc-gzip"`, http.StatusOK, `"abc-gzip"`},
+		{ETagSuffix, "If-Match", `"xyz-gzip"`, http.StatusPreconditionFailed, `"abc"`},
+		{ETagWeaken, "If-None-Match", `W/"abc"`, http.StatusNotModified, `W/"abc"`},
+		{ETagWeaken, "If-None-Match", `"abc"`, http.StatusNotModified, `"abc"`},
+		{ETagWeaken, "If-Match", `W/"abc"`, http.StatusPreconditionFailed, `"abc"`},
+	}
+
+	for _, tt := range tests {
+		wrapper, _ := GzipHandlerWithOpts(ETags(tt.mode), BrotliLevel(4))
+		handler := wrapper(newETagHandler(`"abc"`))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set(tt.header, tt.value)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedCode, resp.Code, tt.header, tt.value)
+		assert.Equal(t, tt.expectedETag, resp.Header().Get("Etag"), tt.header, tt.value)
+		// The handler's request is a copy.
+		assert.Equal(t, tt.value, req.Header.Get(tt.header))
+	}
+}
+
+func TestETagsConditionalRequestsOtherCoding(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(ETags(ETagSuffix), BrotliLevel(4))
+	handler := wrapper(newETagHandler(`"abc"`))
+
+	// The client has the gzip representation, but would be sent another.
+	for _, tt := range []struct{ acceptEncoding, expectedETag string }{
+		{"br", `"abc-br"`},
+		{"identity", `"abc"`},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		req.Header.Set("If-None-Match", `"abc-gzip"`)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedETag, resp.Header().Get("Etag"), tt.acceptEncoding)
+	}
+}
+
+func TestUnsuffixETagList(t *testing.T) {
+	tests := []struct {
+		value            string
+		expectedValue    string
+		expectedSuffixed bool
+	}{
+		{`"abc"`, `"abc"`, false},
+		{`"abc-gzip"`, `"abc"`, true},
+		{`W/"abc-br", "def-gzip"`, `W/"abc-br", "def"`, true},
+		{` "a,b-gzip" ,,"c-deflate"`, `"a,b", "c-deflate"`, true},
+		{`*`, `*`, false},
+		{`"unterminated-gzip`, `"unterminated-gzip`, false},
+		{`bogus, "abc-gzip"`, `bogus, "abc"`, true},
+		{``, ``, false},
+	}
+
+	for _, tt := range tests {
+		value, suffixed := unsuffixETagList(tt.value, "gzip")
+		assert.Equal(t, tt.expectedValue, value, tt.value)
+		assert.Equal(t, tt.expectedSuffixed, suffixed, tt.value)
+	}
+}
+
+// newETagHandler returns a handler serving testBody with http.ServeContent,
+// which handles conditional requests, under the given ETag.
+func newETagHandler(etag string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", etag)
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(testBody))
+	})
+}
@@ -0,0 +1,225 @@
+package gziphandler
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/NYTimes/gziphandler/negotiation"
+)
+
+// precompressedExtensions maps content-codings to the extension of the
+// precompressed siblings served by FileServer.
+var precompressedExtensions = map[string]string{
+	"zstd": ".zst",
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// fileServer is the http.Handler returned by FileServer.
+type fileServer struct {
+	fsys        fs.FS
+	codings     []string  // Keys of precompressedExtensions, in order of preference.
+	manifest    *Manifest // Nil unless configured with PrecompressedManifest.
+	manifestDir string    // Directory of the manifest, which its paths are relative to.
+	sniff       func([]byte) string
+	fallback    http.Handler
+}
+
+// FileServer returns a handler which serves HTTP requests with the contents of
+// fsys, like http.FileServer(http.FS(fsys)), but serves precompressed files
+// where they exist: a request for "app.js" from a client accepting br is
+// served the contents of "app.js.br", if there is such a file. ".zst" and
+// ".gz" siblings are served for zstd and gzip in the same way. The
+// Content-Type is that of the original file, and Range and conditional
+// requests apply to the chosen file, as they should.
+//
+// Files without any precompressed sibling are compressed on the fly, as if
+// served through GzipHandlerWithOpts with the same options, unless
+// PrecompressedManifest lists them. Files with siblings are never compressed
+// on the fly, so a client which accepts none of the precompressed
+// content-codings gets the original file.
+//
+// Ties between content-codings the client accepts equally are broken in
+// favour of zstd, then br, then gzip, unless PreferredEncodings says
+// otherwise.
+func FileServer(fsys fs.FS, opts ...option) (http.Handler, error) {
+	c, err := newConfig(forFileServer, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	codings := []string{"zstd", "br", "gzip"}
+	less := c.preferenceLess()
+	sort.SliceStable(codings, func(i, j int) bool {
+		return less(codings[i], codings[j])
+	})
+
+	s := &fileServer{
+		fsys:     fsys,
+		codings:  codings,
+		sniff:    c.sniff,
+		fallback: c.handler(http.FileServer(http.FS(fsys))),
+	}
+	if c.manifest != "" {
+		if s.manifest, err = ReadManifest(fsys, c.manifest); err != nil {
+			return nil, err
+		}
+		s.manifestDir = path.Dir(c.manifest)
+	}
+	return s, nil
+}
+
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+	// Leave redirects and directory listings to http.FileServer.
+	if strings.HasSuffix(upath, "/index.html") {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean(upath), "/")
+	if strings.HasSuffix(upath, "/") {
+		name = path.Join(name, "index.html")
+	}
+
+	// Files listed in the manifest without variants weren't worth
+	// compressing, so they aren't compressed on the fly either.
+	available, entry := s.available(name)
+	if len(available) == 0 && entry == nil {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	coding := negotiation.Identity
+	if len(available) > 0 {
+		w.Header().Add(vary, acceptEncoding)
+		if values := r.Header.Values(acceptEncoding); len(values) > 0 {
+			coding, _ = negotiation.Negotiate(strings.Join(values, ","), available)
+		}
+	}
+
+	if w.Header().Get(contentType) == "" {
+		if ct := s.contentType(name); ct != "" {
+			w.Header().Set(contentType, ct)
+		}
+	}
+
+	variant := name
+	if coding != negotiation.Identity {
+		variant += precompressedExtensions[coding]
+		w.Header().Set(contentEncoding, coding)
+	}
+	if entry != nil && w.Header().Get(etag) == "" {
+		hash := entry.SHA256
+		if coding != negotiation.Identity {
+			hash = entry.Variants[coding].SHA256
+		}
+		if len(hash) > 16 {
+			hash = hash[:16]
+		}
+		w.Header().Set(etag, `"`+hash+`"`)
+	}
+	s.serveFile(w, r, variant)
+}
+
+// available returns the content-codings of the precompressed siblings of the
+// named file, in order of preference, along with its manifest entry if there
+// is a manifest.
+func (s *fileServer) available(name string) ([]string, *ManifestFile) {
+	var available []string
+
+	if s.manifest != nil {
+		rel, ok := s.manifestPath(name)
+		if !ok {
+			return nil, nil
+		}
+		entry, ok := s.manifest.Files[rel]
+		if !ok {
+			return nil, nil
+		}
+		for _, coding := range s.codings {
+			if _, ok := entry.Variants[coding]; ok {
+				available = append(available, coding)
+			}
+		}
+		return available, &entry
+	}
+
+	fi, err := fs.Stat(s.fsys, name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+	for _, coding := range s.codings {
+		vfi, err := fs.Stat(s.fsys, name+precompressedExtensions[coding])
+		if err == nil && vfi.Mode().IsRegular() {
+			available = append(available, coding)
+		}
+	}
+	return available, nil
+}
+
+// manifestPath returns the path of the named file relative to the manifest,
+// and whether the file is in the directory of the manifest at all.
+func (s *fileServer) manifestPath(name string) (string, bool) {
+	if s.manifestDir == "." {
+		return name, true
+	}
+	rel := strings.TrimPrefix(name, s.manifestDir+"/")
+	return rel, rel != name
+}
+
+// contentType returns the Content-Type of the named file, from its extension
+// or, failing that, its contents.
+func (s *fileServer) contentType(name string) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, _ := io.ReadFull(f, buf[:])
+	return s.sniff(buf[:n])
+}
+
+// serveFile serves the named file with http.ServeContent, which takes care of
+// Range and conditional requests.
+func (s *fileServer) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		w.Header().Del(contentEncoding)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		w.Header().Del(contentEncoding)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			w.Header().Del(contentEncoding)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	http.ServeContent(w, r, name, fi.ModTime(), content)
+}
@@ -0,0 +1,240 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFS = fstest.MapFS{
+	"app.js":             {Data: []byte(testBody)},
+	"app.js.gz":          {Data: []byte("gzip variant")},
+	"app.js.br":          {Data: []byte("br variant")},
+	"app.js.zst":         {Data: []byte("zstd variant")},
+	"style.css":          {Data: []byte(testBody)},
+	"style.css.gz":       {Data: gzipStrLevel(testBody, gzip.BestCompression), ModTime: time.Unix(1e9, 0)},
+	"plain.txt":          {Data: []byte(testBody)},
+	"noext":              {Data: []byte("<html><body>" + testBody)},
+	"noext.gz":           {Data: []byte("gzip variant")},
+	"docs/index.html":    {Data: []byte("<html>" + testBody)},
+	"docs/index.html.br": {Data: []byte("br variant")},
+}
+
+func TestFileServer(t *testing.T) {
+	handler, err := FileServer(testFS)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tests := []struct {
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
+		expectedType     string
+		expectedBody     string
+	}{
+		{"/app.js", "gzip, deflate, br, zstd", "zstd", "text/javascript; charset=utf-8", "zstd variant"},
+		{"/app.js", "gzip, deflate, br", "br", "text/javascript; charset=utf-8", "br variant"},
+		{"/app.js", "gzip", "gzip", "text/javascript; charset=utf-8", "gzip variant"},
+		{"/app.js", "gzip;q=0.5, br;q=0.1", "gzip", "text/javascript; charset=utf-8", "gzip variant"},
+		{"/app.js", "", "", "text/javascript; charset=utf-8", testBody},
+		{"/app.js", "deflate", "", "text/javascript; charset=utf-8", testBody},
+		{"/style.css", "br, gzip", "gzip", "text/css; charset=utf-8", string(gzipStrLevel(testBody, gzip.BestCompression))},
+		{"/noext", "gzip", "gzip", "text/html; charset=utf-8", "gzip variant"},
+		{"/docs/", "br", "br", "text/html; charset=utf-8", "br variant"},
+		// Without a precompressed sibling, the file is compressed on the fly.
+		{"/plain.txt", "gzip", "gzip", "text/plain; charset=utf-8", string(gzipStrLevel(testBody, gzip.DefaultCompression))},
+		{"/plain.txt", "br", "", "text/plain; charset=utf-8", testBody},
+		// Precompressed files can still be requested directly.
+		{"/app.js.gz", "", "", "application/gzip", "gzip variant"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, 200, resp.Code, tt.path, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.path, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedType, resp.Header().Get("Content-Type"), tt.path, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedBody, resp.Body.String(), tt.path, tt.acceptEncoding)
+		if tt.path != "/app.js.gz" {
+			assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"), tt.path, tt.acceptEncoding)
+		}
+	}
+}
+
+func TestFileServerPreferredEncodings(t *testing.T) {
+	handler, _ := FileServer(testFS, PreferredEncodings("gzip", "br"))
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, br, zstd")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzip variant", resp.Body.String())
+}
+
+func TestFileServerRange(t *testing.T) {
+	handler, _ := FileServer(testFS)
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=5-11")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusPartialContent, resp.Code)
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, "bytes 5-11/12", resp.Header().Get("Content-Range"))
+	assert.Equal(t, "variant", resp.Body.String())
+}
+
+func TestFileServerConditional(t *testing.T) {
+	handler, _ := FileServer(testFS)
+
+	req := httptest.NewRequest("GET", "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-Modified-Since", time.Unix(1e9, 0).UTC().Format(http.TimeFormat))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Empty(t, body)
+}
+
+func TestFileServerFallback(t *testing.T) {
+	handler, _ := FileServer(testFS)
+
+	for path, code := range map[string]int{
+		"/missing.js":       http.StatusNotFound,
+		"/docs":             http.StatusMovedPermanently,
+		"/docs/index.html":  http.StatusMovedPermanently,
+		"/../../etc/passwd": http.StatusNotFound,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = path
+		req.Header.Set("Accept-Encoding", "gzip, br")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, code, resp.Code, path)
+	}
+}
+
+func TestFileServerManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte(testBody)},
+		"app.js.gz": {Data: []byte("gzip variant")},
+		"app.js.br": {Data: []byte("br variant")},
+		"other.js":  {Data: []byte(testBody)},
+		// Not in the manifest, so never served.
+		"other.js.gz": {Data: []byte("gzip variant")},
+		"plain.txt":   {Data: []byte(testBody)},
+		"precompressed.json": {Data: []byte(`{"files": {
+			"app.js": {"size": 1, "sha256": "0123456789abcdef0123", "variants": {
+				"br": {"size": 1, "sha256": "fedcba9876543210fedc"}
+			}},
+			"plain.txt": {"size": 1, "sha256": "00112233445566778899"}
+		}}`)},
+	}
+
+	handler, err := FileServer(fsys, PrecompressedManifest(DefaultManifestName))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tests := []struct {
+		path             string
+		acceptEncoding   string
+		expectedEncoding string
+		expectedEtag     string
+	}{
+		{"/app.js", "gzip, br", "br", `"fedcba9876543210"`},
+		{"/app.js", "gzip", "", `"0123456789abcdef"`},
+		{"/other.js", "gzip", "gzip", ""},
+		// Listed without variants, so not compressed on the fly either.
+		{"/plain.txt", "gzip", "", `"0011223344556677"`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.path, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedEtag, resp.Header().Get("Etag"), tt.path, tt.acceptEncoding)
+		switch tt.path {
+		case "/other.js":
+			assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), resp.Body.Bytes())
+		case "/plain.txt":
+			assert.Equal(t, testBody, resp.Body.String())
+			assert.Equal(t, "", resp.Header().Get("Vary"))
+		}
+	}
+
+	// ETags make conditional requests work per variant.
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	req.Header.Set("If-None-Match", `"fedcba9876543210"`)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNotModified, resp.Code)
+
+	_, err = FileServer(fsys, PrecompressedManifest("missing.json"))
+	assert.Error(t, err)
+}
+
+func TestFileServerManifestInSubdirectory(t *testing.T) {
+	fsys := fstest.MapFS{
+		"static/app.js":    {Data: []byte(testBody)},
+		"static/app.js.br": {Data: []byte("br variant")},
+		"static/precompressed.json": {Data: []byte(`{"files": {
+			"app.js": {"size": 1, "sha256": "0123456789abcdef0123", "variants": {
+				"br": {"size": 1, "sha256": "fedcba9876543210fedc"}
+			}}
+		}}`)},
+		// Outside the directory of the manifest, despite the same relative path.
+		"app.js":    {Data: []byte(testBody)},
+		"app.js.br": {Data: []byte("br variant")},
+	}
+
+	handler, err := FileServer(fsys, PrecompressedManifest("static/"+DefaultManifestName))
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	tests := []struct {
+		path             string
+		expectedEncoding string
+		expectedEtag     string
+	}{
+		{"/static/app.js", "br", `"fedcba9876543210"`},
+		{"/app.js", "gzip", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, br;q=0.9")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.path)
+		assert.Equal(t, tt.expectedEtag, resp.Header().Get("Etag"), tt.path)
+		if tt.expectedEncoding == "br" {
+			assert.Equal(t, "br variant", resp.Body.String(), tt.path)
+		}
+	}
+}
@@ -1,5 +1,14 @@
 module github.com/NYTimes/gziphandler
 
-go 1.11
+go 1.21
 
-require github.com/stretchr/testify v1.3.0
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/klauspost/compress v1.17.9
+	github.com/stretchr/testify v1.3.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
@@ -5,12 +5,18 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/NYTimes/gziphandler/negotiation"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
@@ -19,15 +25,12 @@ const (
 	contentEncoding = "Content-Encoding"
 	contentType     = "Content-Type"
 	contentLength   = "Content-Length"
+	etag            = "Etag"
 )
 
-type codings map[string]float64
-
 const (
 	// DefaultQValue is the default qvalue to assign to an encoding if no explicit qvalue is set.
-	// This is actually kind of ambiguous in RFC 2616, so hopefully it's correct.
-	// The examples seem to indicate that it is.
-	DefaultQValue = 1.0
+	DefaultQValue = negotiation.DefaultQValue
 
 	// DefaultMinSize is the default minimum size until we enable gzip compression.
 	// 1500 bytes is the MTU size for the internet since that is the largest size allowed at the network layer.
@@ -58,26 +61,71 @@ func poolIndex(level int) int {
 	return level - gzip.BestSpeed
 }
 
-func addLevelPool(level int) {
-	gzipWriterPools[poolIndex(level)] = &sync.Pool{
-		New: func() interface{} {
-			// NewWriterLevel only returns error on a bad level, we are guaranteeing
-			// that this will be a valid level so it is okay to ignore the returned
-			// error.
-			w, _ := gzip.NewWriterLevel(nil, level)
-			return w
-		},
+// gzipEncoderFactory is the built-in EncoderFactory for the gzip
+// content-coding. Its Encoders are pooled in gzipWriterPools.
+type gzipEncoderFactory struct {
+	level int
+}
+
+func (f gzipEncoderFactory) Name() string {
+	return "gzip"
+}
+
+func (f gzipEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	gw, err := gzip.NewWriterLevel(w, f.level)
+	if err != nil {
+		return nil, err
 	}
+	return gw, nil
+}
+
+func (f gzipEncoderFactory) pool() *sync.Pool {
+	return gzipWriterPools[poolIndex(f.level)]
+}
+
+func (f gzipEncoderFactory) compressionLevel() int {
+	return f.level
+}
+
+// gzipReaderPool stores gzip.Readers for reuse.
+var gzipReaderPool sync.Pool
+
+// gzipDecoderFactory is the built-in DecoderFactory for the gzip
+// content-coding. Its Decoders are pooled in gzipReaderPool.
+type gzipDecoderFactory struct{}
+
+func (f gzipDecoderFactory) Name() string {
+	return "gzip"
+}
+
+func (f gzipDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return gr, nil
+}
+
+func (f gzipDecoderFactory) pool() *sync.Pool {
+	return &gzipReaderPool
+}
+
+// addLevelPool creates an empty pool for the given level. The pool has no New
+// func: gzipEncoderFactory creates the gzip.Writers, so that encoderPool.get
+// can tell new ones from reused ones.
+func addLevelPool(level int) {
+	gzipWriterPools[poolIndex(level)] = &sync.Pool{}
 }
 
 // GzipResponseWriter provides an http.ResponseWriter interface, which gzips
-// bytes before writing them to the underlying response. This doesn't close the
-// writers, so don't forget to do that.
+// (or otherwise encodes, see Encoders) bytes before writing them to the
+// underlying response. This doesn't close the writers, so don't forget to do
+// that.
 // It can be configured to skip response smaller than minSize.
 type GzipResponseWriter struct {
 	http.ResponseWriter
-	index int // Index for gzipWriterPools.
-	gw    *gzip.Writer
+	pool *encoderPool // Pool of the negotiated content-coding.
+	gw   Encoder
 
 	code int // Saves the WriteHeader value.
 
@@ -85,7 +133,37 @@ type GzipResponseWriter struct {
 	buf     []byte // Holds the first part of the write before reaching the minSize or the end of the write.
 	ignore  bool   // If true, then we immediately passthru writes to the underlying ResponseWriter.
 
-	contentTypes []parsedContentType // Only compress if the response is one of these content-types. All are accepted if empty.
+	contentTypes         []parsedContentType // Only compress if the response is one of these content-types. All are accepted if empty.
+	excludedContentTypes []parsedContentType // Never compress if the response is one of these content-types.
+	sniff                func([]byte) string // Guesses the content-type of the response if there isn't one.
+
+	cache    *ResponseCache // Nil unless configured with CacheResponses.
+	cacheKey string         // Key of the response in cache, less its validator. Empty if it can't be cached.
+	capture  *captureWriter // Copy of the compressed response, for the cache.
+
+	head    bool // If true, the request is a HEAD one, whose response has no body.
+	discard bool // If true, the response was served from cache or is to a HEAD request, and writes are discarded.
+
+	disabled bool // If true, DisableCompression was called.
+
+	decision Decision       // What was done with the response, and why.
+	observe  func(Decision) // Nil unless configured with Observer.
+	dst      *meteredWriter // Where the Encoder writes.
+	metrics  *Metrics       // Nil unless configured with CollectMetrics.
+
+	etagMode        ETagMode // How to rewrite the ETag of the compressed response.
+	notModifiedETag bool     // If true, a 304 response refers to a compressed representation, so its ETag is rewritten too.
+
+	rangePolicy RangePolicy // Whether to remove the Accept-Ranges header of the compressed response.
+
+	timing bool        // If true, ServerTiming is enabled for the request.
+	held   *heldWriter // Holds back the compressed response until the Server-Timing header is known.
+
+	logger *slog.Logger // Nil unless configured with Logger.
+	failed bool         // If true, a failure was logged already.
+
+	onError    func(http.ResponseWriter, *http.Request, error) // Called when the Encoder fails, as configured with ErrorHandler.
+	encoderErr error                                           // The first failure of the Encoder.
 }
 
 type GzipResponseWriterWithCloseNotify struct {
@@ -98,14 +176,29 @@ func (w GzipResponseWriterWithCloseNotify) CloseNotify() <-chan bool {
 
 // Write appends data to the gzip writer.
 func (w *GzipResponseWriter) Write(b []byte) (int, error) {
+	w.decision.UncompressedBytes += int64(len(b))
+
 	// GZIP responseWriter is initialized. Use the GZIP responseWriter.
 	if w.gw != nil {
-		return w.gw.Write(b)
+		var n int
+		err := w.encode(func() (err error) {
+			n, err = w.gw.Write(b)
+			return err
+		})
+		return n, w.encoderFailed("write", err)
+	}
+
+	// The response was served from the cache, or has no body, so the
+	// handler's copy isn't needed.
+	if w.discard {
+		return len(b), nil
 	}
 
 	// If we have already decided not to use GZIP, immediately passthrough.
 	if w.ignore {
-		return w.ResponseWriter.Write(b)
+		n, err := w.ResponseWriter.Write(b)
+		w.logError("write", err)
+		return n, err
 	}
 
 	// Save the write into a buffer for later use in GZIP responseWriter (if content is long enough) or at close with regular responseWriter.
@@ -115,32 +208,42 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 	var (
 		cl, _ = strconv.Atoi(w.Header().Get(contentLength))
 		ct    = w.Header().Get(contentType)
-		ce    = w.Header().Get(contentEncoding)
 	)
 	// Only continue if they didn't already choose an encoding or a known unhandled content length or type.
-	if ce == "" && (cl == 0 || cl >= w.minSize) && (ct == "" || handleContentType(w.contentTypes, ct)) {
+	reason := w.skipReason(cl, ct)
+	if reason == ReasonUnknown {
 		// If the current buffer is less than minSize and a Content-Length isn't set, then wait until we have more data.
 		if len(w.buf) < w.minSize && cl == 0 {
 			return len(b), nil
 		}
 		// If the Content-Length is larger than minSize or the current buffer is larger than minSize, then continue.
 		if cl >= w.minSize || len(w.buf) >= w.minSize {
-			// If a Content-Type wasn't specified, infer it from the current buffer.
+			// If a Content-Type wasn't specified, infer it from the current
+			// buffer. If the handler said not to, keep the guess to ourselves,
+			// and stop net/http from making its own.
 			if ct == "" {
-				ct = http.DetectContentType(w.buf)
-				w.Header().Set(contentType, ct)
+				ct = w.sniff(w.buf)
+				if nosniff(w.Header()) {
+					w.Header()[contentType] = nil
+				} else {
+					w.Header().Set(contentType, ct)
+				}
 			}
 			// If the Content-Type is acceptable to GZIP, initialize the GZIP writer.
-			if handleContentType(w.contentTypes, ct) {
+			if handleContentType(w.contentTypes, w.excludedContentTypes, ct) {
 				if err := w.startGzip(); err != nil {
+					w.logError("write", err)
 					return 0, err
 				}
 				return len(b), nil
 			}
+			reason = ReasonContentType
 		}
 	}
 	// If we got here, we should not GZIP this response.
+	w.decision.Reason = reason
 	if err := w.startPlain(); err != nil {
+		w.logError("write", err)
 		return 0, err
 	}
 	return len(b), nil
@@ -149,27 +252,62 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 // startGzip initializes a GZIP writer and writes the buffer.
 func (w *GzipResponseWriter) startGzip() error {
 	// Set the GZIP header.
-	w.Header().Set(contentEncoding, "gzip")
+	w.Header().Set(contentEncoding, w.pool.name)
+	w.decision.Reason = ReasonCompressed
+	w.decision.Encoding = w.pool.name
+	w.decision.Level = factoryLevel(w.pool.factory)
+	w.rewriteETag()
+	if w.rangePolicy == RangeCompressFull {
+		w.Header().Del(acceptRanges)
+	}
 
 	// if the Content-Length is already set, then calls to Write on gzip
 	// will fail to set the Content-Length header since its already set
 	// See: https://github.com/golang/go/issues/14975.
 	w.Header().Del(contentLength)
 
+	if ok, err := w.serveCached(); ok {
+		return err
+	}
+
+	// Hold back the header of the compressed response, and the response
+	// itself, until the Server-Timing header can be set. Nothing is
+	// compressed in response to a HEAD request, so it can be set right away.
+	if w.timing && w.head {
+		w.Header().Add(serverTiming, timingEntry(w.decision))
+	} else if w.timing && w.held == nil {
+		w.held = &heldWriter{w: w}
+	}
+
 	// Write the header to gzip response.
-	if w.code != 0 {
+	if w.held == nil && w.code != 0 {
 		w.ResponseWriter.WriteHeader(w.code)
 		// Ensure that no other WriteHeader's happen
 		w.code = 0
 	}
 
+	// The response to a HEAD request has the headers of the response to the
+	// matching GET request, but there's no point compressing a body which
+	// won't be sent.
+	if w.head {
+		w.discard = true
+		w.buf = nil
+		return nil
+	}
+
 	// Initialize and flush the buffer into the gzip response if there are any bytes.
 	// If there aren't any, we shouldn't initialize it yet because on Close it will
 	// write the gzip header even if nothing was ever written.
 	if len(w.buf) > 0 {
 		// Initialize the GZIP response.
-		w.init()
-		n, err := w.gw.Write(w.buf)
+		if err := w.init(); err != nil {
+			return w.encoderFailed("write", err)
+		}
+		var n int
+		err := w.encode(func() (err error) {
+			n, err = w.gw.Write(w.buf)
+			return err
+		})
 
 		// This should never happen (per io.Writer docs), but if the write didn't
 		// accept the entire buffer but returned no specific error, we have no clue
@@ -177,13 +315,19 @@ func (w *GzipResponseWriter) startGzip() error {
 		if err == nil && n < len(w.buf) {
 			err = io.ErrShortWrite
 		}
-		return err
+		return w.encoderFailed("write", err)
 	}
 	return nil
 }
 
 // startPlain writes to sent bytes and buffer the underlying ResponseWriter without gzip.
 func (w *GzipResponseWriter) startPlain() error {
+	if w.code == http.StatusNotModified && w.notModifiedETag {
+		w.rewriteETag()
+	}
+	if w.timing {
+		w.Header().Add(serverTiming, timingEntry(w.decision))
+	}
 	if w.code != 0 {
 		w.ResponseWriter.WriteHeader(w.code)
 		// Ensure that no other WriteHeader's happen
@@ -205,31 +349,108 @@ func (w *GzipResponseWriter) startPlain() error {
 	return err
 }
 
+// skipReason returns why the response mustn't be compressed, judging by its
+// headers, given its Content-Length and Content-Type (zero and empty if
+// unknown), or ReasonUnknown if it may be.
+func (w *GzipResponseWriter) skipReason(cl int, ct string) Reason {
+	switch {
+	case w.Header().Get(contentEncoding) != "":
+		return ReasonAlreadyEncoded
+	case w.optedOut():
+		return ReasonOptedOut
+	case bodylessStatus(w.code):
+		return ReasonNoBody
+	case w.partial():
+		return ReasonPartialContent
+	case cl != 0 && cl < w.minSize:
+		return ReasonTooSmall
+	case ct != "" && !handleContentType(w.contentTypes, w.excludedContentTypes, ct):
+		return ReasonContentType
+	}
+	return ReasonUnknown
+}
+
+// compressibleHead returns whether the response to a HEAD request the handler
+// didn't write a body for would have been compressed, were it the response to
+// a GET request with a body of the given Content-Length.
+func (w *GzipResponseWriter) compressibleHead() bool {
+	cl, _ := strconv.Atoi(w.Header().Get(contentLength))
+	return cl > 0 && w.skipReason(cl, w.Header().Get(contentType)) == ReasonUnknown &&
+		handleContentType(w.contentTypes, w.excludedContentTypes, w.Header().Get(contentType))
+}
+
+// bodylessStatus returns whether responses with the given status code never
+// have a body: informational ones, 204 No Content and 304 Not Modified.
+func bodylessStatus(code int) bool {
+	return (code >= 100 && code < 200) || code == http.StatusNoContent || code == http.StatusNotModified
+}
+
 // WriteHeader just saves the response code until close or GZIP effective writes.
+// Informational (1xx) responses other than 101 Switching Protocols, such as
+// 103 Early Hints, precede the final response, so they're sent right away.
 func (w *GzipResponseWriter) WriteHeader(code int) {
-	if w.code == 0 {
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		if w.code == 0 && w.gw == nil && !w.ignore && !w.discard {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	if w.code == 0 && w.decision.Status == 0 {
 		w.code = code
+		w.decision.Status = code
 	}
 }
 
-// init graps a new gzip writer from the gzipWriterPool and writes the correct
-// content encoding header.
-func (w *GzipResponseWriter) init() {
-	// Bytes written during ServeHTTP are redirected to this gzip writer
+// init grabs a new Encoder from the pool of the negotiated content-coding.
+func (w *GzipResponseWriter) init() error {
+	// Bytes written during ServeHTTP are redirected to this encoder
 	// before being written to the underlying response.
-	gzw := gzipWriterPools[w.index].Get().(*gzip.Writer)
-	gzw.Reset(w.ResponseWriter)
-	w.gw = gzw
+	var out io.Writer = w.ResponseWriter
+	if w.held != nil {
+		out = w.held
+	}
+	w.dst = &meteredWriter{Writer: out, timed: w.observe != nil || w.timing}
+	var dst io.Writer = w.dst
+	if w.capture != nil {
+		w.capture.Writer = dst
+		dst = w.capture
+	}
+	gw, reused, err := w.pool.get(dst)
+	if w.metrics != nil {
+		w.metrics.poolGet(w.pool, reused)
+	}
+	if err != nil {
+		return err
+	}
+	w.gw = gw
+	return nil
 }
 
-// Close will close the gzip.Writer and will put it back in the gzipWriterPool.
-func (w *GzipResponseWriter) Close() error {
-	if w.ignore {
+// Close will close the Encoder and will put it back in its pool.
+func (w *GzipResponseWriter) Close() (err error) {
+	defer func() {
+		w.logError("close", err)
+	}()
+
+	if w.ignore || w.discard {
 		return nil
 	}
 
+	if w.gw == nil && w.head && w.compressibleHead() {
+		return w.startGzip()
+	}
+
 	if w.gw == nil {
 		// GZIP not triggered yet, write out regular response.
+		cl, _ := strconv.Atoi(w.Header().Get(contentLength))
+		w.decision.Reason = w.skipReason(cl, w.Header().Get(contentType))
+		if w.decision.Reason == ReasonUnknown {
+			if len(w.buf) == 0 {
+				w.decision.Reason = ReasonNoBody
+			} else {
+				w.decision.Reason = ReasonTooSmall
+			}
+		}
 		err := w.startPlain()
 		// Returns the error if any at write.
 		if err != nil {
@@ -238,13 +459,21 @@ func (w *GzipResponseWriter) Close() error {
 		return err
 	}
 
-	err := w.gw.Close()
-	gzipWriterPools[w.index].Put(w.gw)
+	err = w.encoderFailed("close", w.encode(w.gw.Close))
+	w.pool.put(w.gw)
 	w.gw = nil
+	if w.held != nil {
+		if herr := w.held.finish(); err == nil {
+			err = herr
+		}
+	}
+	if err == nil && w.capture != nil && !w.capture.overflow {
+		w.cache.add(w.capture.key, w.capture.buf.Bytes())
+	}
 	return err
 }
 
-// Flush flushes the underlying *gzip.Writer and then the underlying
+// Flush flushes the underlying Encoder and then the underlying
 // http.ResponseWriter if it is an http.Flusher. This makes GzipResponseWriter
 // an http.Flusher.
 func (w *GzipResponseWriter) Flush() {
@@ -257,7 +486,11 @@ func (w *GzipResponseWriter) Flush() {
 	}
 
 	if w.gw != nil {
-		w.gw.Flush()
+		err := w.encoderFailed("flush", w.encode(w.gw.Flush))
+		if err == nil && w.held != nil {
+			err = w.held.stream()
+		}
+		w.logError("flush", err)
 	}
 
 	if fw, ok := w.ResponseWriter.(http.Flusher); ok {
@@ -304,45 +537,109 @@ func NewGzipLevelAndMinSize(level, minSize int) (func(http.Handler) http.Handler
 }
 
 func GzipHandlerWithOpts(opts ...option) (func(http.Handler) http.Handler, error) {
-	c := &config{
-		level:   gzip.DefaultCompression,
-		minSize: DefaultMinSize,
-	}
-
-	for _, o := range opts {
-		o(c)
-	}
-
-	if err := c.validate(); err != nil {
+	c, err := newConfig(forHandler, opts...)
+	if err != nil {
 		return nil, err
 	}
+	return c.handler, nil
+}
 
-	return func(h http.Handler) http.Handler {
-		index := poolIndex(c.level)
+// handler wraps h in a handler compressing its responses as configured by c.
+func (c *config) handler(h http.Handler) http.Handler {
+	pools := c.encoderPools()
+	observe := c.observe()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(vary, acceptEncoding)
+		pool, identity := negotiateEncoding(r, pools)
+		r, notModifiedETag := conditionalETags(r, c.etagMode, pool)
+		if pool == nil && !identity && c.notAcceptable {
+			serveUncompressed(notAcceptable, w, r, ReasonNotAcceptable, observe)
+			return
+		}
 
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add(vary, acceptEncoding)
-			if acceptsGzip(r) {
-				gw := &GzipResponseWriter{
-					ResponseWriter: w,
-					index:          index,
-					minSize:        c.minSize,
-					contentTypes:   c.contentTypes,
-				}
-				defer gw.Close()
+		if pool != nil && r.Header.Get(rangeHeader) != "" {
+			if c.rangePolicy == RangeSkipCompression {
+				serveUncompressed(h, w, r, ReasonRange, observe)
+				return
+			}
+			r = withoutRange(r)
+		}
 
-				if _, ok := w.(http.CloseNotifier); ok {
-					gwcn := GzipResponseWriterWithCloseNotify{gw}
-					h.ServeHTTP(gwcn, r)
-				} else {
-					h.ServeHTTP(gw, r)
-				}
+		if pool != nil {
+			minSize := c.minSize
+			if !identity {
+				// The client refuses uncompressed responses, so compress
+				// even those we would usually consider too small.
+				minSize = 0
+			}
+			gw := &GzipResponseWriter{
+				ResponseWriter:       w,
+				pool:                 pool,
+				minSize:              minSize,
+				contentTypes:         c.contentTypes,
+				excludedContentTypes: c.excludedContentTypes,
+				sniff:                c.sniff,
+				head:                 r.Method == http.MethodHead,
+
+				etagMode:        c.etagMode,
+				notModifiedETag: notModifiedETag,
+
+				rangePolicy: c.rangePolicy,
+
+				timing: c.serverTiming != nil && c.serverTiming(r),
+
+				decision: Decision{Request: r},
+				observe:  observe,
+				metrics:  c.metrics,
+				logger:   c.logger,
+				onError:  c.errorHandler,
+			}
+			if c.cache != nil {
+				gw.cache = c.cache
+				gw.cacheKey = cacheKey(r, pool)
+			}
+			// Observers are told about responses aborted by the
+			// ErrorHandler too.
+			if observe != nil {
+				defer func() {
+					observe(gw.Decision())
+				}()
+			}
+			defer gw.Close()
 
+			if _, ok := w.(http.CloseNotifier); ok {
+				gwcn := GzipResponseWriterWithCloseNotify{gw}
+				h.ServeHTTP(gwcn, r)
 			} else {
-				h.ServeHTTP(w, r)
+				h.ServeHTTP(gw, r)
 			}
-		})
-	}, nil
+
+		} else {
+			serveUncompressed(h, w, r, ReasonNotAccepted, observe)
+		}
+	})
+}
+
+// notAcceptable responds with 406 Not Acceptable.
+var notAcceptable = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+})
+
+// serveUncompressed serves r with h, without compressing the response, and
+// reports the reason to observe, if not nil.
+func serveUncompressed(h http.Handler, w http.ResponseWriter, r *http.Request, reason Reason, observe func(Decision)) {
+	if observe == nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+	ow := &observedWriter{ResponseWriter: w}
+	if _, ok := w.(http.CloseNotifier); ok {
+		h.ServeHTTP(observedWriterWithCloseNotify{ow}, r)
+	} else {
+		h.ServeHTTP(ow, r)
+	}
+	observe(ow.decision(r, reason))
 }
 
 // Parsed representation of one of the inputs to ContentTypes.
@@ -352,9 +649,9 @@ type parsedContentType struct {
 	params    map[string]string
 }
 
-// equals returns whether this content type matches another content type.
-func (pct parsedContentType) equals(mediaType string, params map[string]string) bool {
-	if pct.mediaType != mediaType {
+// matches returns whether this content type matches another content type.
+func (pct parsedContentType) matches(mediaType string, params map[string]string) bool {
+	if !matchMediaType(pct.mediaType, mediaType) {
 		return false
 	}
 	// if pct has no params, don't care about other's params
@@ -374,37 +671,235 @@ func (pct parsedContentType) equals(mediaType string, params map[string]string)
 	return true
 }
 
+// matchMediaType returns whether mediaType matches pattern, whose type or
+// subtype may be "*", to match any, and whose subtype may be "*+suffix", to
+// match any with that structured syntax suffix, e.g. "application/*+json"
+// matches "application/ld+json".
+func matchMediaType(pattern, mediaType string) bool {
+	if pattern == mediaType {
+		return true
+	}
+	ptype, psubtype, _ := strings.Cut(pattern, "/")
+	mtype, msubtype, _ := strings.Cut(mediaType, "/")
+	if ptype != "*" && ptype != mtype {
+		return false
+	}
+	switch {
+	case psubtype == "*":
+		return true
+	case strings.HasPrefix(psubtype, "*+"):
+		suffix := psubtype[1:]
+		return len(msubtype) > len(suffix) && strings.HasSuffix(msubtype, suffix)
+	default:
+		return psubtype == msubtype
+	}
+}
+
 // Used for functional configuration.
 type config struct {
+	scope      scope    // The constructor the config is for.
+	misapplied []string // Names of the options which don't apply to it.
+	invalid    []error  // Errors in the arguments of the options, found as they're applied.
+
 	minSize      int
 	level        int
 	contentTypes []parsedContentType
+	encoders     []EncoderFactory
+	decoders     []DecoderFactory
+
+	excludedContentTypes []parsedContentType
+	sniff                func([]byte) string
+
+	brotli      bool
+	brotliLevel int
+
+	zstd           bool
+	zstdLevel      zstd.EncoderLevel
+	zstdWindowSize int
+
+	deflate      bool
+	deflateLevel int
+
+	notAcceptable bool
+
+	uploadEncoding string
+
+	manifest string
+
+	etagMode ETagMode
+
+	observers []func(Decision)
+	metrics   *Metrics
+	logger    *slog.Logger
+
+	errorHandler func(http.ResponseWriter, *http.Request, error)
+
+	rangePolicy RangePolicy
+
+	serverTiming func(*http.Request) bool
+
+	cache *ResponseCache
+
+	maxDecompressedSize   int64
+	maxDecompressionRatio float64
+	decompressTimeout     time.Duration
+
+	preferredEncodings []string
+}
+
+// newConfig returns the default configuration with opts applied, or an error
+// if the result is invalid.
+func newConfig(s scope, opts ...option) (*config, error) {
+	c := &config{
+		scope:               s,
+		level:               gzip.DefaultCompression,
+		minSize:             DefaultMinSize,
+		maxDecompressedSize: DefaultMaxDecompressedSize,
+		sniff:               DetectContentType,
+		errorHandler:        abortResponse,
+	}
+
+	for _, o := range opts {
+		o(c)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func (c *config) validate() error {
-	if c.level != gzip.DefaultCompression && (c.level < gzip.BestSpeed || c.level > gzip.BestCompression) {
+	if len(c.misapplied) > 0 {
+		return fmt.Errorf("%s does not apply to %s", c.misapplied[0], scopeNames[c.scope])
+	}
+	if len(c.invalid) > 0 {
+		return c.invalid[0]
+	}
+
+	if !validLevel(c.level) {
 		return fmt.Errorf("invalid compression level requested: %d", c.level)
 	}
 
+	if c.deflate && !validLevel(c.deflateLevel) {
+		return fmt.Errorf("invalid deflate compression level requested: %d", c.deflateLevel)
+	}
+
+	if c.brotli && (c.brotliLevel < brotli.BestSpeed || c.brotliLevel > brotli.BestCompression) {
+		return fmt.Errorf("invalid brotli quality requested: %d", c.brotliLevel)
+	}
+
+	if c.zstd {
+		if err := (zstdEncoderFactory{level: c.zstdLevel, windowSize: c.zstdWindowSize}).validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.etagMode < ETagKeep || c.etagMode > ETagSuffix {
+		return fmt.Errorf("invalid ETag mode requested: %d", c.etagMode)
+	}
+
+	if c.rangePolicy < RangeSkipCompression || c.rangePolicy > RangeCompressFull {
+		return fmt.Errorf("invalid range policy requested: %d", c.rangePolicy)
+	}
+
 	if c.minSize < 0 {
 		return fmt.Errorf("minimum size must be more than zero")
 	}
 
+	if c.sniff == nil {
+		return fmt.Errorf("sniffer must not be nil")
+	}
+
+	if c.errorHandler == nil {
+		return fmt.Errorf("error handler must not be nil")
+	}
+
+	for _, o := range c.observers {
+		if o == nil {
+			return fmt.Errorf("observer must not be nil")
+		}
+	}
+
+	for _, f := range c.encoders {
+		if f == nil || f.Name() == "" {
+			return fmt.Errorf("encoder must have a content-coding name")
+		}
+		if name := strings.ToLower(f.Name()); name == "identity" || name == "*" {
+			return fmt.Errorf("invalid encoder content-coding name: %q", f.Name())
+		}
+	}
+
+	if c.maxDecompressionRatio < 0 {
+		return fmt.Errorf("decompression ratio limit must not be negative")
+	}
+
+	if c.decompressTimeout < 0 {
+		return fmt.Errorf("decompression timeout must not be negative")
+	}
+
+	for _, f := range c.decoders {
+		if f == nil || f.Name() == "" {
+			return fmt.Errorf("decoder must have a content-coding name")
+		}
+	}
+
 	return nil
 }
 
+// validLevel returns whether level is a compression level accepted by
+// CompressionLevel and DeflateLevel, i.e. one with a pool in gzipWriterPools
+// and deflateWriterPools.
+func validLevel(level int) bool {
+	return level == gzip.DefaultCompression || (level >= gzip.BestSpeed && level <= gzip.BestCompression)
+}
+
+// option configures the handlers and Transport of this package. Each
+// constructor rejects the options which don't apply to it.
 type option func(c *config)
 
-func MinSize(size int) option {
+// scope is a set of the constructors taking options.
+type scope uint8
+
+const (
+	forHandler    scope = 1 << iota // GzipHandlerWithOpts
+	forFileServer                   // FileServer
+	forDecompress                   // DecompressHandlerWithOpts
+	forTransport                    // NewTransport
+
+	// compressing are the constructors of handlers compressing responses.
+	compressing = forHandler | forFileServer
+)
+
+var scopeNames = map[scope]string{
+	forHandler:    "GzipHandlerWithOpts",
+	forFileServer: "FileServer",
+	forDecompress: "DecompressHandlerWithOpts",
+	forTransport:  "NewTransport",
+}
+
+// scoped returns the option named name, which applies set to the config of
+// the constructors in s, and makes the others fail.
+func scoped(name string, s scope, set func(c *config)) option {
 	return func(c *config) {
-		c.minSize = size
+		if c.scope&s == 0 {
+			c.misapplied = append(c.misapplied, name)
+			return
+		}
+		set(c)
 	}
 }
 
+func MinSize(size int) option {
+	return scoped("MinSize", compressing|forTransport, func(c *config) {
+		c.minSize = size
+	})
+}
+
 func CompressionLevel(level int) option {
-	return func(c *config) {
+	return scoped("CompressionLevel", compressing|forTransport, func(c *config) {
 		c.level = level
-	}
+	})
 }
 
 // ContentTypes specifies a list of content types to compare
@@ -423,18 +918,68 @@ func CompressionLevel(level int) option {
 // that has the same MIME type and other directives. I.e.,
 // "text/html; charset=utf-8" will only match "text/html; charset=utf-8".
 //
+// The type or subtype of a MIME type may be "*" to match any, and the
+// subtype may be "*+suffix" to match any with that structured syntax suffix.
+// I.e., "text/*" will match "text/html" and "text/css", and
+// "application/*+json" will match "application/ld+json" but not
+// "application/json".
+//
 // By default, responses are gzipped regardless of
 // Content-Type.
 func ContentTypes(types []string) option {
-	return func(c *config) {
-		c.contentTypes = []parsedContentType{}
-		for _, v := range types {
-			mediaType, params, err := mime.ParseMediaType(v)
-			if err == nil {
-				c.contentTypes = append(c.contentTypes, parsedContentType{mediaType, params})
-			}
+	return scoped("ContentTypes", compressing, func(c *config) {
+		c.contentTypes = parseContentTypes(types)
+	})
+}
+
+// IncompressibleContentTypes lists the content types of common formats which
+// are already compressed, for use with ExcludeContentTypes.
+var IncompressibleContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"image/heic",
+	"image/heif",
+	"image/jxl",
+	"video/*",
+	"audio/*",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/vnd.rar",
+	"application/x-rar-compressed",
+}
+
+// ExcludeContentTypes specifies a list of content types which are never
+// compressed, even if they're matched by ContentTypes, e.g.
+// IncompressibleContentTypes. They're matched like those of ContentTypes.
+//
+// By default, no content type is excluded.
+func ExcludeContentTypes(types []string) option {
+	return scoped("ExcludeContentTypes", compressing, func(c *config) {
+		c.excludedContentTypes = parseContentTypes(types)
+	})
+}
+
+// parseContentTypes parses the content types given to ContentTypes and
+// ExcludeContentTypes, ignoring invalid ones.
+func parseContentTypes(types []string) []parsedContentType {
+	parsed := []parsedContentType{}
+	for _, v := range types {
+		mediaType, params, err := mime.ParseMediaType(v)
+		if err == nil {
+			parsed = append(parsed, parsedContentType{mediaType, params})
 		}
 	}
+	return parsed
 }
 
 // GzipHandler wraps an HTTP handler, to transparently gzip the response body if
@@ -445,88 +990,33 @@ func GzipHandler(h http.Handler) http.Handler {
 	return wrapper(h)
 }
 
-// acceptsGzip returns true if the given HTTP request indicates that it will
-// accept a gzipped response.
-func acceptsGzip(r *http.Request) bool {
-	acceptedEncodings, _ := parseEncodings(r.Header.Get(acceptEncoding))
-	return acceptedEncodings["gzip"] > 0.0
-}
-
 // returns true if we've been configured to compress the specific content type.
-func handleContentType(contentTypes []parsedContentType, ct string) bool {
-	// If contentTypes is empty we handle all content types.
-	if len(contentTypes) == 0 {
+func handleContentType(contentTypes, excludedContentTypes []parsedContentType, ct string) bool {
+	// If contentTypes is empty we handle all content types, bar the excluded ones.
+	if len(contentTypes) == 0 && len(excludedContentTypes) == 0 {
 		return true
 	}
 
 	mediaType, params, err := mime.ParseMediaType(ct)
 	if err != nil {
-		return false
-	}
-
-	for _, c := range contentTypes {
-		if c.equals(mediaType, params) {
-			return true
-		}
+		return len(contentTypes) == 0
 	}
 
-	return false
-}
-
-// parseEncodings attempts to parse a list of codings, per RFC 2616, as might
-// appear in an Accept-Encoding header. It returns a map of content-codings to
-// quality values, and an error containing the errors encountered. It's probably
-// safe to ignore those, because silently ignoring errors is how the internet
-// works.
-//
-// See: http://tools.ietf.org/html/rfc2616#section-14.3.
-func parseEncodings(s string) (codings, error) {
-	c := make(codings)
-	var e []string
-
-	for _, ss := range strings.Split(s, ",") {
-		coding, qvalue, err := parseCoding(ss)
-
-		if err != nil {
-			e = append(e, err.Error())
-		} else {
-			c[coding] = qvalue
+	for _, c := range excludedContentTypes {
+		if c.matches(mediaType, params) {
+			return false
 		}
 	}
 
-	// TODO (adammck): Use a proper multi-error struct, so the individual errors
-	//                 can be extracted if anyone cares.
-	if len(e) > 0 {
-		return c, fmt.Errorf("errors while parsing encodings: %s", strings.Join(e, ", "))
+	if len(contentTypes) == 0 {
+		return true
 	}
 
-	return c, nil
-}
-
-// parseCoding parses a single conding (content-coding with an optional qvalue),
-// as might appear in an Accept-Encoding header. It attempts to forgive minor
-// formatting errors.
-func parseCoding(s string) (coding string, qvalue float64, err error) {
-	for n, part := range strings.Split(s, ";") {
-		part = strings.TrimSpace(part)
-		qvalue = DefaultQValue
-
-		if n == 0 {
-			coding = strings.ToLower(part)
-		} else if strings.HasPrefix(part, "q=") {
-			qvalue, err = strconv.ParseFloat(strings.TrimPrefix(part, "q="), 64)
-
-			if qvalue < 0.0 {
-				qvalue = 0.0
-			} else if qvalue > 1.0 {
-				qvalue = 1.0
-			}
+	for _, c := range contentTypes {
+		if c.matches(mediaType, params) {
+			return true
 		}
 	}
 
-	if coding == "" {
-		err = fmt.Errorf("empty content-coding")
-	}
-
-	return
+	return false
 }
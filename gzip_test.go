@@ -3,15 +3,21 @@ package gziphandler
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -21,27 +27,6 @@ const (
 	testBody      = "aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc aaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbcccaaabbbccc"
 )
 
-func TestParseEncodings(t *testing.T) {
-	examples := map[string]codings{
-
-		// Examples from RFC 2616
-		"compress, gzip":                     {"compress": 1.0, "gzip": 1.0},
-		"":                                   {},
-		"*":                                  {"*": 1.0},
-		"compress;q=0.5, gzip;q=1.0":         {"compress": 0.5, "gzip": 1.0},
-		"gzip;q=1.0, identity; q=0.5, *;q=0": {"gzip": 1.0, "identity": 0.5, "*": 0.0},
-
-		// More random stuff
-		"AAA;q=1":     {"aaa": 1.0},
-		"BBB ; q = 2": {"bbb": 1.0},
-	}
-
-	for eg, exp := range examples {
-		act, _ := parseEncodings(eg)
-		assert.Equal(t, exp, act)
-	}
-}
-
 func TestGzipHandler(t *testing.T) {
 	// This just exists to provide something for GzipHandler to wrap.
 	handler := newTestHandler(testBody)
@@ -154,6 +139,33 @@ func TestMustNewGzipLevelHandlerWillPanic(t *testing.T) {
 	_ = MustNewGzipLevelHandler(-42)
 }
 
+func TestOptionsMustApply(t *testing.T) {
+	_, err := GzipHandlerWithOpts(UploadEncoding("gzip"))
+	assert.EqualError(t, err, "UploadEncoding does not apply to GzipHandlerWithOpts")
+	_, err = GzipHandlerWithOpts(MaxDecompressedSize(1))
+	assert.Error(t, err)
+	_, err = GzipHandlerWithOpts(PrecompressedManifest("x"))
+	assert.Error(t, err)
+	_, err = GzipHandlerWithOpts(Decoders(gzipDecoderFactory{}))
+	assert.Error(t, err)
+
+	// Options which don't apply aren't validated.
+	_, err = DecompressHandlerWithOpts(CompressionLevel(42))
+	assert.EqualError(t, err, "CompressionLevel does not apply to DecompressHandlerWithOpts")
+	_, err = DecompressHandlerWithOpts(MaxDecompressedSize(1), Decoders(gzipDecoderFactory{}))
+	assert.Nil(t, err)
+
+	_, err = NewTransport(nil, ContentTypes([]string{"text/plain"}))
+	assert.Error(t, err)
+	_, err = NewTransport(nil, UploadEncoding("br"), BrotliLevel(5), MinSize(10), Decoders(gzipDecoderFactory{}))
+	assert.Nil(t, err)
+
+	_, err = FileServer(os.DirFS("testdata"), MaxDecompressionRatio(10))
+	assert.Error(t, err)
+	_, err = FileServer(os.DirFS("testdata"), ContentTypes([]string{"text/plain"}), CompressionLevel(5))
+	assert.Nil(t, err)
+}
+
 func TestGzipHandlerNoBody(t *testing.T) {
 	tests := []struct {
 		statusCode      int
@@ -343,7 +355,8 @@ func TestGzipDoubleClose(t *testing.T) {
 	w1 := gzipWriterPools[poolIndex(gzip.DefaultCompression)].Get()
 	w2 := gzipWriterPools[poolIndex(gzip.DefaultCompression)].Get()
 	// assert.NotEqual looks at the value and not the address, so we use regular ==
-	assert.False(t, w1 == w2)
+	// The pool has no New func, so the second Get returns nil if it's empty.
+	assert.False(t, w1 != nil && w1 == w2)
 }
 
 type panicOnSecondWriteHeaderWriter struct {
@@ -393,11 +406,143 @@ func TestGzipHandlerDoubleWriteHeader(t *testing.T) {
 	}
 	assert.Empty(t, body)
 	header := rec.Header()
-	assert.Equal(t, "gzip", header.Get("Content-Encoding"))
+	// A 304 has no body, so it's never compressed.
+	assert.Equal(t, "", header.Get("Content-Encoding"))
 	assert.Equal(t, "Accept-Encoding", header.Get("Vary"))
 	assert.Equal(t, 304, rec.Code)
 }
 
+func TestGzipHandlerBodylessStatusCodes(t *testing.T) {
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		for _, minSize := range []int{0, DefaultMinSize} {
+			wrapper, _ := GzipHandlerWithOpts(MinSize(minSize))
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(code)
+				// A buffering handler may well write an empty body.
+				w.Write(nil)
+				w.(http.Flusher).Flush()
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Equal(t, code, resp.Code)
+			assert.Empty(t, resp.Header().Get("Content-Encoding"), "%d with MinSize(%d)", code, minSize)
+			assert.Empty(t, resp.Body.Bytes(), "%d with MinSize(%d)", code, minSize)
+		}
+	}
+}
+
+func TestGzipHandlerHead(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		// The handler writes the body, which net/http discards.
+		{"body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, r.URL.Query().Get("body"))
+		}},
+		// The handler only sets the Content-Length, as http.ServeContent does.
+		{"Content-Length", func(w http.ResponseWriter, r *http.Request) {
+			body := r.URL.Query().Get("body")
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			if r.Method != http.MethodHead {
+				io.WriteString(w, body)
+			}
+		}},
+		{"ServeContent", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(r.URL.Query().Get("body")))
+		}},
+	}
+
+	for _, tt := range tests {
+		wrapper, _ := GzipHandlerWithOpts(ContentTypes([]string{"text/plain"}))
+		handler := wrapper(tt.handler)
+
+		for _, body := range []string{testBody, "small"} {
+			target := "/?body=" + url.QueryEscape(body)
+			get := httptest.NewRequest("GET", target, nil)
+			get.Header.Set("Accept-Encoding", "gzip")
+			getResp := httptest.NewRecorder()
+			handler.ServeHTTP(getResp, get)
+
+			head := httptest.NewRequest("HEAD", target, nil)
+			head.Header.Set("Accept-Encoding", "gzip")
+			headResp := httptest.NewRecorder()
+			handler.ServeHTTP(headResp, head)
+
+			assert.Equal(t, getResp.Code, headResp.Code, tt.name)
+			assert.Equal(t, getResp.Header().Get("Content-Encoding"), headResp.Header().Get("Content-Encoding"), tt.name, len(body))
+			assert.Equal(t, getResp.Header().Get("Content-Type"), headResp.Header().Get("Content-Type"), tt.name, len(body))
+			assert.Equal(t, getResp.Header().Get("Vary"), headResp.Header().Get("Vary"), tt.name, len(body))
+			if getResp.Header().Get("Content-Encoding") != "" {
+				// The length of the compressed body isn't known without compressing it.
+				assert.Empty(t, headResp.Header().Get("Content-Length"), tt.name)
+			} else {
+				assert.Equal(t, getResp.Header().Get("Content-Length"), headResp.Header().Get("Content-Length"), tt.name)
+			}
+		}
+	}
+}
+
+func TestGzipHandlerHeadDiscardsBody(t *testing.T) {
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+		io.WriteString(w, testBody)
+	}))
+
+	req := httptest.NewRequest("HEAD", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	// The body isn't compressed only to be discarded by net/http, unlike
+	// httptest.ResponseRecorder which would record it.
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Empty(t, resp.Body.Bytes())
+}
+
+func TestGzipHandlerEarlyHints(t *testing.T) {
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, testBody)
+		// Too late for hints.
+		w.WriteHeader(http.StatusEarlyHints)
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	var informational []int
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			informational = append(informational, code)
+			assert.Equal(t, "</style.css>; rel=preload; as=style", header.Get("Link"))
+			return nil
+		},
+	}
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	assert.Equal(t, []int{http.StatusEarlyHints}, informational)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), body)
+}
+
 func TestStatusCodes(t *testing.T) {
 	handler := GzipHandler(http.NotFoundHandler())
 	r := httptest.NewRequest("GET", "/", nil)
@@ -576,6 +721,103 @@ var contentTypeTests = []struct {
 		acceptedContentTypes: []string{"application/json;            charset=utf-8"},
 		expectedGzip:         true,
 	},
+	{
+		name:                 "MIME subtype wildcard",
+		contentType:          "text/css; charset=utf-8",
+		acceptedContentTypes: []string{"text/*"},
+		expectedGzip:         true,
+	},
+	{
+		name:                 "MIME subtype wildcard no match",
+		contentType:          "image/png",
+		acceptedContentTypes: []string{"text/*"},
+		expectedGzip:         false,
+	},
+	{
+		name:                 "MIME structured syntax suffix",
+		contentType:          "application/ld+json",
+		acceptedContentTypes: []string{"application/*+json"},
+		expectedGzip:         true,
+	},
+	{
+		name:                 "MIME structured syntax suffix no match",
+		contentType:          "application/json",
+		acceptedContentTypes: []string{"application/*+json", "application/*+xml"},
+		expectedGzip:         false,
+	},
+}
+
+func TestMatchMediaType(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		mediaType string
+		expected  bool
+	}{
+		{"text/html", "text/html", true},
+		{"text/html", "text/plain", false},
+		{"text/*", "text/plain", true},
+		{"text/*", "texts/plain", false},
+		{"*/*", "image/png", true},
+		{"*/json", "application/json", true},
+		{"application/*+json", "application/problem+json", true},
+		{"application/*+json", "application/json", false},
+		{"application/*+json", "application/+json", false},
+		{"application/*+json", "text/x+json", false},
+		{"application/*+xml", "application/atom+xml", true},
+		{"application/*+xml", "application/atom+xmlx", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, matchMediaType(tt.pattern, tt.mediaType), tt.pattern, tt.mediaType)
+	}
+}
+
+func TestExcludeContentTypes(t *testing.T) {
+	tests := []struct {
+		contentType          string
+		acceptedContentTypes []string
+		excludedContentTypes []string
+		expectedGzip         bool
+	}{
+		{"image/png", nil, []string{"image/*"}, false},
+		{"text/plain", nil, []string{"image/*"}, true},
+		{"", nil, []string{"image/*"}, true},
+		{"image/svg+xml", []string{"image/*"}, []string{"image/png"}, true},
+		{"image/png", []string{"image/*"}, []string{"image/png"}, false},
+		{"image/png", nil, IncompressibleContentTypes, false},
+		{"video/mp4", nil, IncompressibleContentTypes, false},
+		{"font/woff2", nil, IncompressibleContentTypes, false},
+		{"application/zip", nil, IncompressibleContentTypes, false},
+		{"image/svg+xml", nil, IncompressibleContentTypes, true},
+		{"application/wasm", nil, IncompressibleContentTypes, true},
+		{"not a content type", nil, IncompressibleContentTypes, true},
+	}
+
+	for _, tt := range tests {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", tt.contentType)
+			io.WriteString(w, testBody)
+		})
+
+		opts := []option{ExcludeContentTypes(tt.excludedContentTypes)}
+		if tt.acceptedContentTypes != nil {
+			opts = append(opts, ContentTypes(tt.acceptedContentTypes))
+		}
+		wrapper, err := GzipHandlerWithOpts(opts...)
+		assert.Nil(t, err)
+
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		wrapper(handler).ServeHTTP(resp, req)
+
+		if tt.expectedGzip {
+			assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"), tt.contentType)
+		} else {
+			assert.Empty(t, resp.Header().Get("Content-Encoding"), tt.contentType)
+			assert.Equal(t, testBody, resp.Body.String(), tt.contentType)
+		}
+	}
 }
 
 func TestContentTypes(t *testing.T) {
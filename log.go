@@ -0,0 +1,90 @@
+package gziphandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Logger makes the handler log to l: the Decision made for each response at
+// the debug level, and the first failure to write, flush or close each
+// response at the warn level, e.g. because the client went away or an
+// Encoder failed. Both are logged with the method, URL and remote address of
+// the request, and the context of the request is passed to the slog.Handler.
+func Logger(l *slog.Logger) option {
+	return scoped("Logger", compressing, func(c *config) {
+		if l == nil {
+			c.invalid = append(c.invalid, fmt.Errorf("logger must not be nil"))
+			return
+		}
+		c.logger = l
+		c.observers = append(c.observers, func(d Decision) {
+			logDecision(l, d)
+		})
+	})
+}
+
+// logDecision logs d at the debug level.
+func logDecision(l *slog.Logger, d Decision) {
+	ctx := requestContext(d.Request)
+	if !l.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Int("status", d.Status),
+		slog.String("reason", d.Reason.String()),
+	}
+	if d.Compressed() {
+		attrs = append(attrs,
+			slog.String("encoding", d.Encoding),
+			slog.Int("compression_level", d.Level),
+			slog.Duration("cpu_time", d.CPUTime),
+		)
+	}
+	attrs = append(attrs,
+		slog.Int64("uncompressed_bytes", d.UncompressedBytes),
+		slog.Int64("compressed_bytes", d.CompressedBytes),
+		requestAttr(d.Request),
+	)
+	l.LogAttrs(ctx, slog.LevelDebug, "gziphandler: response", attrs...)
+}
+
+// logError logs the failure of op on the response at the warn level, unless
+// an earlier one was logged, since they tend to come in bunches when the
+// connection is lost.
+func (w *GzipResponseWriter) logError(op string, err error) {
+	if err == nil || w.logger == nil || w.failed {
+		return
+	}
+	w.failed = true
+	attrs := []slog.Attr{
+		slog.String("op", op),
+		slog.Any("error", err),
+	}
+	if w.decision.Encoding != "" {
+		attrs = append(attrs, slog.String("encoding", w.decision.Encoding))
+	}
+	attrs = append(attrs, requestAttr(w.decision.Request))
+	ctx := requestContext(w.decision.Request)
+	w.logger.LogAttrs(ctx, slog.LevelWarn, "gziphandler: failed to "+op+" response", attrs...)
+}
+
+// requestAttr returns the attributes of r worth logging, as a group.
+func requestAttr(r *http.Request) slog.Attr {
+	if r == nil {
+		return slog.Attr{}
+	}
+	return slog.Group("request",
+		slog.String("method", r.Method),
+		slog.String("url", r.URL.String()),
+		slog.String("remote_addr", r.RemoteAddr),
+	)
+}
+
+func requestContext(r *http.Request) context.Context {
+	if r == nil {
+		return context.Background()
+	}
+	return r.Context()
+}
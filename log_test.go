@@ -0,0 +1,156 @@
+package gziphandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logLines returns the JSON records logged to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &m))
+		lines = append(lines, m)
+	}
+	return lines
+}
+
+func TestLoggerDecisions(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	wrapper, err := GzipHandlerWithOpts(Logger(l))
+	assert.Nil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	req, _ := http.NewRequest("GET", "/whatever?q=1", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req.Header.Del("Accept-Encoding")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logLines(t, &buf)
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	assert.Equal(t, "DEBUG", lines[0]["level"])
+	assert.Equal(t, "gziphandler: response", lines[0]["msg"])
+	assert.Equal(t, float64(200), lines[0]["status"])
+	assert.Equal(t, "compressed", lines[0]["reason"])
+	assert.Equal(t, "gzip", lines[0]["encoding"])
+	assert.Equal(t, float64(-1), lines[0]["compression_level"])
+	assert.Equal(t, float64(len(testBody)), lines[0]["uncompressed_bytes"])
+	assert.True(t, lines[0]["compressed_bytes"].(float64) < float64(len(testBody)))
+	assert.Equal(t, map[string]any{
+		"method":      "GET",
+		"url":         "/whatever?q=1",
+		"remote_addr": "192.0.2.1:1234",
+	}, lines[0]["request"])
+
+	assert.Equal(t, "not_accepted", lines[1]["reason"])
+	assert.NotContains(t, lines[1], "encoding")
+	assert.Equal(t, float64(len(testBody)), lines[1]["compressed_bytes"])
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+	wrapper, _ := GzipHandlerWithOpts(Logger(l))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, buf.String())
+
+	_, err := GzipHandlerWithOpts(Logger(nil))
+	assert.EqualError(t, err, "logger must not be nil")
+}
+
+// failingResponseWriter fails every write once more than limit bytes have
+// been written.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+var errBrokenPipe = errors.New("broken pipe")
+
+func (w *failingResponseWriter) Write(b []byte) (int, error) {
+	if w.Body.Len()+len(b) > w.limit {
+		return 0, errBrokenPipe
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestLoggerFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded bool
+		limit   int
+		writes  int
+		flush   bool
+		op      string
+	}{
+		{"plain", false, 0, 2, false, "write"},
+		{"compressed", true, 0, 3, false, "write"},
+		{"flush", true, 10, 1, true, "flush"},
+		{"close", true, 10, 1, false, "close"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		l := slog.New(slog.NewJSONHandler(&buf, nil))
+		wrapper, _ := GzipHandlerWithOpts(Logger(l))
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tt.encoded {
+				DisableCompression(w)
+			}
+			for i := 0; i < tt.writes; i++ {
+				io.WriteString(w, testBody)
+			}
+			if tt.flush {
+				w.(http.Flusher).Flush()
+			}
+		}))
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		// Encoder failures abort the response, once logged.
+		assert.Equal(t, tt.encoded, panics(func() {
+			handler.ServeHTTP(&failingResponseWriter{httptest.NewRecorder(), tt.limit}, req)
+		}) == http.ErrAbortHandler, tt.name)
+
+		lines := logLines(t, &buf)
+		if !assert.Len(t, lines, 1, tt.name) {
+			continue
+		}
+		assert.Equal(t, "WARN", lines[0]["level"], tt.name)
+		assert.Equal(t, "gziphandler: failed to "+tt.op+" response", lines[0]["msg"], tt.name)
+		assert.Equal(t, tt.op, lines[0]["op"], tt.name)
+		assert.Contains(t, lines[0]["error"], "broken pipe", tt.name)
+		assert.Contains(t, lines[0], "request", tt.name)
+		if tt.encoded {
+			assert.Equal(t, "gzip", lines[0]["encoding"], tt.name)
+		} else {
+			assert.NotContains(t, lines[0], "encoding", tt.name)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package gziphandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+)
+
+// DefaultManifestName is the name of the manifest written by the precompress
+// command (see cmd/precompress) next to the files it precompresses.
+const DefaultManifestName = "precompressed.json"
+
+// Manifest describes a tree of files and their precompressed siblings, as
+// written by the precompress command. FileServer uses it, when configured
+// with PrecompressedManifest, instead of looking for the siblings itself.
+type Manifest struct {
+	// Files is indexed by slash-separated path, relative to the manifest.
+	Files map[string]ManifestFile `json:"files"`
+}
+
+// ManifestFile describes one original file in a Manifest.
+type ManifestFile struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // Hex-encoded.
+
+	// Variants holds the precompressed siblings of the file, indexed by
+	// content-coding. Content-codings which didn't make the file small
+	// enough to be worth it are missing.
+	Variants map[string]ManifestVariant `json:"variants,omitempty"`
+}
+
+// ManifestVariant describes one precompressed sibling of a file in a
+// Manifest. Its name is that of the file with PrecompressedExtension of its
+// content-coding appended.
+type ManifestVariant struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // Hex-encoded.
+}
+
+// PrecompressedExtension returns the extension of the precompressed siblings
+// of files for the given content-coding, e.g. ".br" for "br", and whether
+// FileServer supports the content-coding at all.
+func PrecompressedExtension(coding string) (string, bool) {
+	ext, ok := precompressedExtensions[coding]
+	return ext, ok
+}
+
+// ReadManifest reads the named Manifest from fsys.
+func ReadManifest(fsys fs.FS, name string) (*Manifest, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("gziphandler: invalid manifest %s: %v", name, err)
+	}
+	return m, nil
+}
+
+// PrecompressedManifest makes the handler returned by FileServer read the
+// named Manifest from its file system, and rely on it to know which
+// precompressed siblings exist. This saves looking for them on every request,
+// and lets FileServer send strong ETags derived from the hashes in the
+// manifest. The paths in the manifest are relative to its directory, so
+// "static/precompressed.json" describes the files under "static". Files
+// listed without variants, which weren't worth precompressing, are served
+// uncompressed. Files missing from the manifest, or outside its directory, are
+// compressed on the fly.
+func PrecompressedManifest(name string) option {
+	return scoped("PrecompressedManifest", forFileServer, func(c *config) {
+		c.manifest = name
+	})
+}
@@ -0,0 +1,356 @@
+package gziphandler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets of the histograms collected by Metrics.
+var (
+	ratioBuckets   = []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}
+	latencyBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+)
+
+const (
+	openMetricsType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	prometheusType  = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Metrics aggregates the Decisions of the handlers configured with
+// CollectMetrics, along with the use they make of the pools of Encoders. It's
+// safe for concurrent use, and can be shared between handlers.
+//
+// Metrics is an http.Handler serving the metrics in the Prometheus text
+// exposition format, or OpenMetrics if the client asks for it, so it can be
+// scraped by Prometheus:
+//
+//	gziphandler_requests_total{reason,encoding}         counter
+//	gziphandler_uncompressed_bytes_total{encoding}      counter
+//	gziphandler_compressed_bytes_total{encoding}        counter
+//	gziphandler_compression_ratio{encoding}             histogram
+//	gziphandler_compression_seconds{encoding}           histogram
+//	gziphandler_encoder_pool_gets_total{encoding,level} counter
+//	gziphandler_encoder_pool_news_total{encoding,level} counter
+//
+// The encoding label is "identity" for uncompressed responses. The ratio is
+// that of compressed to uncompressed bytes, and the duration is the CPUTime of
+// the Decision.
+//
+// Metrics is also an expvar.Var, whose String method returns the same metrics
+// as JSON, so it can be published with expvar.Publish.
+type Metrics struct {
+	mu sync.Mutex
+
+	requests          map[requestLabels]uint64
+	uncompressedBytes map[string]uint64 // By encoding.
+	compressedBytes   map[string]uint64
+	ratio             map[string]*histogram
+	latency           map[string]*histogram
+	pool              map[poolLabels]*poolCounts
+}
+
+type requestLabels struct {
+	reason   Reason
+	encoding string
+}
+
+type poolLabels struct {
+	encoding string
+	level    int
+}
+
+type poolCounts struct {
+	gets, news uint64
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // counts[i] counts the observations <= bounds[i].
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:          make(map[requestLabels]uint64),
+		uncompressedBytes: make(map[string]uint64),
+		compressedBytes:   make(map[string]uint64),
+		ratio:             make(map[string]*histogram),
+		latency:           make(map[string]*histogram),
+		pool:              make(map[poolLabels]*poolCounts),
+	}
+}
+
+// CollectMetrics makes the handler record its Decisions, and the use it makes
+// of the pools of Encoders, in m.
+func CollectMetrics(m *Metrics) option {
+	return scoped("CollectMetrics", compressing, func(c *config) {
+		if m == nil {
+			c.invalid = append(c.invalid, fmt.Errorf("metrics must not be nil"))
+			return
+		}
+		c.metrics = m
+		c.observers = append(c.observers, m.Observe)
+	})
+}
+
+// Observe records a Decision. It's called by the handlers configured with
+// CollectMetrics, and can be called by an Observer to collect the Decisions
+// of a handler selectively.
+func (m *Metrics) Observe(d Decision) {
+	encoding := d.Encoding
+	if encoding == "" {
+		encoding = "identity"
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestLabels{d.Reason, encoding}]++
+	m.uncompressedBytes[encoding] += uint64(d.UncompressedBytes)
+	m.compressedBytes[encoding] += uint64(d.CompressedBytes)
+
+	// Responses to HEAD requests aren't actually compressed.
+	if !d.Compressed() || d.CompressedBytes == 0 || d.UncompressedBytes == 0 {
+		return
+	}
+	h := m.ratio[encoding]
+	if h == nil {
+		h = newHistogram(ratioBuckets)
+		m.ratio[encoding] = h
+	}
+	h.observe(float64(d.CompressedBytes) / float64(d.UncompressedBytes))
+
+	if d.Reason == ReasonCompressed {
+		h := m.latency[encoding]
+		if h == nil {
+			h = newHistogram(latencyBuckets)
+			m.latency[encoding] = h
+		}
+		h.observe(d.CPUTime.Seconds())
+	}
+}
+
+// poolGet records that an Encoder was taken from p, which created a new one
+// unless reused.
+func (m *Metrics) poolGet(p *encoderPool, reused bool) {
+	labels := poolLabels{p.name, factoryLevel(p.factory)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := m.pool[labels]
+	if counts == nil {
+		counts = &poolCounts{}
+		m.pool[labels] = counts
+	}
+	counts.gets++
+	if !reused {
+		counts.news++
+	}
+}
+
+// ServeHTTP serves the metrics in the OpenMetrics text format if the request
+// accepts it, and in the Prometheus text format otherwise.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set(contentType, openMetricsType)
+	} else {
+		w.Header().Set(contentType, prometheusType)
+	}
+
+	bw := bufio.NewWriter(w)
+	m.writeText(bw, openMetrics)
+	bw.Flush()
+}
+
+// writeText writes the metrics in the Prometheus text format, or OpenMetrics.
+func (m *Metrics) writeText(w *bufio.Writer, openMetrics bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// In OpenMetrics, the _total suffix is only part of the sample names.
+	header := func(name, typ, help string) {
+		family := name
+		if openMetrics && typ == "counter" {
+			family = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family, help, family, typ)
+	}
+
+	header("gziphandler_requests_total", "counter", "Responses by compression decision reason and content-coding.")
+	requests := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		requests = append(requests, l)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].reason != requests[j].reason {
+			return requests[i].reason < requests[j].reason
+		}
+		return requests[i].encoding < requests[j].encoding
+	})
+	for _, l := range requests {
+		fmt.Fprintf(w, "gziphandler_requests_total{reason=%s,encoding=%s} %d\n", labelValue(l.reason.String()), labelValue(l.encoding), m.requests[l])
+	}
+
+	header("gziphandler_uncompressed_bytes_total", "counter", "Bytes written by handlers, by content-coding.")
+	for _, encoding := range sortedKeys(m.uncompressedBytes) {
+		fmt.Fprintf(w, "gziphandler_uncompressed_bytes_total{encoding=%s} %d\n", labelValue(encoding), m.uncompressedBytes[encoding])
+	}
+
+	header("gziphandler_compressed_bytes_total", "counter", "Bytes sent to clients, by content-coding.")
+	for _, encoding := range sortedKeys(m.compressedBytes) {
+		fmt.Fprintf(w, "gziphandler_compressed_bytes_total{encoding=%s} %d\n", labelValue(encoding), m.compressedBytes[encoding])
+	}
+
+	header("gziphandler_compression_ratio", "histogram", "Ratio of compressed to uncompressed bytes of compressed responses.")
+	for _, encoding := range sortedHistogramKeys(m.ratio) {
+		writeHistogram(w, "gziphandler_compression_ratio", encoding, m.ratio[encoding])
+	}
+
+	header("gziphandler_compression_seconds", "histogram", "Time spent compressing responses.")
+	for _, encoding := range sortedHistogramKeys(m.latency) {
+		writeHistogram(w, "gziphandler_compression_seconds", encoding, m.latency[encoding])
+	}
+
+	pool := make([]poolLabels, 0, len(m.pool))
+	for l := range m.pool {
+		pool = append(pool, l)
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		if pool[i].encoding != pool[j].encoding {
+			return pool[i].encoding < pool[j].encoding
+		}
+		return pool[i].level < pool[j].level
+	})
+	header("gziphandler_encoder_pool_gets_total", "counter", "Encoders taken from the pools, by content-coding and level.")
+	for _, l := range pool {
+		fmt.Fprintf(w, "gziphandler_encoder_pool_gets_total{encoding=%s,level=\"%d\"} %d\n", labelValue(l.encoding), l.level, m.pool[l].gets)
+	}
+	header("gziphandler_encoder_pool_news_total", "counter", "Encoders created because the pools were empty, by content-coding and level.")
+	for _, l := range pool {
+		fmt.Fprintf(w, "gziphandler_encoder_pool_news_total{encoding=%s,level=\"%d\"} %d\n", labelValue(l.encoding), l.level, m.pool[l].news)
+	}
+
+	if openMetrics {
+		w.WriteString("# EOF\n")
+	}
+}
+
+func writeHistogram(w *bufio.Writer, name, encoding string, h *histogram) {
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{encoding=%s,le=\"%s\"} %d\n", name, labelValue(encoding), formatFloat(b), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{encoding=%s,le=\"+Inf\"} %d\n", name, labelValue(encoding), h.count)
+	fmt.Fprintf(w, "%s_sum{encoding=%s} %s\n", name, labelValue(encoding), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{encoding=%s} %d\n", name, labelValue(encoding), h.count)
+}
+
+// labelValue quotes and escapes a label value.
+func labelValue(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedHistogramKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns the metrics as JSON, which makes Metrics an expvar.Var.
+func (m *Metrics) String() string {
+	type histogramJSON struct {
+		Buckets map[string]uint64 `json:"buckets"`
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+	}
+	type poolJSON struct {
+		Gets uint64 `json:"gets"`
+		News uint64 `json:"news"`
+	}
+	histograms := func(hs map[string]*histogram) map[string]histogramJSON {
+		out := make(map[string]histogramJSON, len(hs))
+		for encoding, h := range hs {
+			buckets := make(map[string]uint64, len(h.bounds))
+			for i, b := range h.bounds {
+				buckets[formatFloat(b)] = h.counts[i]
+			}
+			out[encoding] = histogramJSON{buckets, h.count, h.sum}
+		}
+		return out
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := struct {
+		Requests          map[string]map[string]uint64   `json:"requests"`
+		UncompressedBytes map[string]uint64              `json:"uncompressed_bytes"`
+		CompressedBytes   map[string]uint64              `json:"compressed_bytes"`
+		Ratio             map[string]histogramJSON       `json:"compression_ratio"`
+		Latency           map[string]histogramJSON       `json:"compression_seconds"`
+		Pool              map[string]map[string]poolJSON `json:"encoder_pool"`
+	}{
+		Requests:          make(map[string]map[string]uint64),
+		UncompressedBytes: m.uncompressedBytes,
+		CompressedBytes:   m.compressedBytes,
+		Ratio:             histograms(m.ratio),
+		Latency:           histograms(m.latency),
+		Pool:              make(map[string]map[string]poolJSON),
+	}
+	for l, n := range m.requests {
+		reason := l.reason.String()
+		if v.Requests[reason] == nil {
+			v.Requests[reason] = make(map[string]uint64)
+		}
+		v.Requests[reason][l.encoding] = n
+	}
+	for l, counts := range m.pool {
+		if v.Pool[l.encoding] == nil {
+			v.Pool[l.encoding] = make(map[string]poolJSON)
+		}
+		v.Pool[l.encoding][strconv.Itoa(l.level)] = poolJSON{counts.gets, counts.news}
+	}
+
+	b, _ := json.Marshal(v)
+	return string(b)
+}
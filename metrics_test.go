@@ -0,0 +1,137 @@
+package gziphandler
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	wrapper, err := GzipHandlerWithOpts(CollectMetrics(m), CompressionLevel(5))
+	assert.Nil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/small" {
+			io.WriteString(w, smallTestBody)
+		} else {
+			io.WriteString(w, testBody)
+		}
+	}))
+
+	// Every level is used by other tests, so empty the pool for the count of
+	// new encoders to be exact.
+	for gzipWriterPools[poolIndex(5)].Get() != nil {
+	}
+
+	for _, tt := range []struct{ path, acceptEncoding string }{
+		{"/", "gzip"},
+		{"/", "gzip"},
+		{"/small", "gzip"},
+		{"/", ""},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	text := resp.Body.String()
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header().Get("Content-Type"))
+	for _, line := range []string{
+		"# TYPE gziphandler_requests_total counter",
+		`gziphandler_requests_total{reason="compressed",encoding="gzip"} 2`,
+		`gziphandler_requests_total{reason="not_accepted",encoding="identity"} 1`,
+		`gziphandler_requests_total{reason="too_small",encoding="identity"} 1`,
+		fmt.Sprintf(`gziphandler_uncompressed_bytes_total{encoding="gzip"} %d`, 2*len(testBody)),
+		fmt.Sprintf(`gziphandler_uncompressed_bytes_total{encoding="identity"} %d`, len(testBody)+len(smallTestBody)),
+		"# TYPE gziphandler_compression_ratio histogram",
+		`gziphandler_compression_ratio_bucket{encoding="gzip",le="0.05"} 2`,
+		`gziphandler_compression_ratio_bucket{encoding="gzip",le="+Inf"} 2`,
+		`gziphandler_compression_ratio_count{encoding="gzip"} 2`,
+		`gziphandler_compression_seconds_count{encoding="gzip"} 2`,
+		`gziphandler_encoder_pool_gets_total{encoding="gzip",level="5"} 2`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+	assert.NotContains(t, text, "# EOF")
+
+	// OpenMetrics.
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	resp = httptest.NewRecorder()
+	m.ServeHTTP(resp, req)
+	text = resp.Body.String()
+
+	assert.Equal(t, "application/openmetrics-text; version=1.0.0; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Contains(t, text, "# TYPE gziphandler_requests counter\n")
+	assert.Contains(t, text, `gziphandler_requests_total{reason="compressed",encoding="gzip"} 2`+"\n")
+	assert.True(t, strings.HasSuffix(text, "# EOF\n"))
+
+	// expvar.
+	var v struct {
+		Requests          map[string]map[string]uint64 `json:"requests"`
+		UncompressedBytes map[string]uint64            `json:"uncompressed_bytes"`
+		CompressedBytes   map[string]uint64            `json:"compressed_bytes"`
+		Ratio             map[string]struct {
+			Count uint64 `json:"count"`
+		} `json:"compression_ratio"`
+		Pool map[string]map[string]struct {
+			Gets uint64 `json:"gets"`
+			News uint64 `json:"news"`
+		} `json:"encoder_pool"`
+	}
+	var _ expvar.Var = m
+	if assert.Nil(t, json.Unmarshal([]byte(m.String()), &v)) {
+		assert.Equal(t, uint64(2), v.Requests["compressed"]["gzip"])
+		assert.Equal(t, uint64(len(testBody)+len(smallTestBody)), v.CompressedBytes["identity"])
+		assert.Equal(t, uint64(2), v.Ratio["gzip"].Count)
+		assert.Equal(t, uint64(2), v.Pool["gzip"]["5"].Gets)
+		// The second response reuses the encoder of the first, unless the race
+		// detector made the pool drop it.
+		if !raceEnabled {
+			assert.Equal(t, uint64(1), v.Pool["gzip"]["5"].News)
+		}
+	}
+}
+
+func TestMetricsObserve(t *testing.T) {
+	m := NewMetrics()
+	m.Observe(Decision{Status: 200, Reason: ReasonCompressed, Encoding: "br", UncompressedBytes: 1000, CompressedBytes: 250, CPUTime: 2 * time.Millisecond})
+	m.Observe(Decision{Status: 200, Reason: ReasonCached, Encoding: "br", UncompressedBytes: 1000, CompressedBytes: 250})
+	// HEAD.
+	m.Observe(Decision{Status: 200, Reason: ReasonCompressed, Encoding: "br", UncompressedBytes: 1000})
+	m.Observe(Decision{Status: 200, Reason: ReasonContentType, Encoding: `x"y`, UncompressedBytes: 1})
+
+	resp := httptest.NewRecorder()
+	m.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+	text := resp.Body.String()
+
+	for _, line := range []string{
+		`gziphandler_requests_total{reason="compressed",encoding="br"} 2`,
+		`gziphandler_requests_total{reason="cached",encoding="br"} 1`,
+		`gziphandler_requests_total{reason="content_type",encoding="x\"y"} 1`,
+		`gziphandler_compressed_bytes_total{encoding="br"} 500`,
+		`gziphandler_compression_ratio_bucket{encoding="br",le="0.2"} 0`,
+		`gziphandler_compression_ratio_bucket{encoding="br",le="0.3"} 2`,
+		`gziphandler_compression_ratio_sum{encoding="br"} 0.5`,
+		`gziphandler_compression_seconds_bucket{encoding="br",le="0.001"} 0`,
+		`gziphandler_compression_seconds_bucket{encoding="br",le="0.005"} 1`,
+		`gziphandler_compression_seconds_sum{encoding="br"} 0.002`,
+		`gziphandler_compression_seconds_count{encoding="br"} 1`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+
+	_, err := GzipHandlerWithOpts(CollectMetrics(nil))
+	assert.EqualError(t, err, "metrics must not be nil")
+}
@@ -0,0 +1,55 @@
+package gziphandler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/NYTimes/gziphandler/negotiation"
+)
+
+// negotiateEncoding chooses a content-coding for the response to the given
+// HTTP request, as described by negotiation.Negotiate. It returns the pool of
+// the chosen content-coding, or nil if the identity coding (i.e. no
+// compression) is preferred or nothing is acceptable. Ties are broken by the
+// order of pools.
+//
+// The returned bool reports whether the identity coding is acceptable to the
+// client at all. If it returns nil and false, there's no acceptable
+// representation of the response.
+func negotiateEncoding(r *http.Request, pools []*encoderPool) (*encoderPool, bool) {
+	values := r.Header.Values(acceptEncoding)
+	// Without an Accept-Encoding header any content-coding is acceptable,
+	// but in practice clients which don't send one can't decode anything.
+	if len(values) == 0 {
+		return nil, true
+	}
+	codings, _ := negotiation.Parse(strings.Join(values, ","))
+	accepted := negotiation.NewAccepted(codings)
+
+	names := make([]string, len(pools))
+	for i, p := range pools {
+		names[i] = p.name
+	}
+	name, _ := accepted.Negotiate(names)
+
+	identityQ, listed := accepted.Identity()
+	identity := !listed || identityQ > 0
+	for _, p := range pools {
+		if p.name == name {
+			return p, identity
+		}
+	}
+	return nil, identity
+}
+
+// NotAcceptable makes the handler respond with 406 Not Acceptable when the
+// client's Accept-Encoding header rules out both the identity coding and all
+// the content-codings the handler can produce, e.g. "identity;q=0" or
+// "*;q=0" from a client which only accepts gzip when gzip is disabled.
+//
+// By default such requests are served uncompressed, as RFC 9110 allows.
+func NotAcceptable(enabled bool) option {
+	return scoped("NotAcceptable", compressing, func(c *config) {
+		c.notAcceptable = enabled
+	})
+}
@@ -0,0 +1,101 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	c := &config{
+		level:       gzip.DefaultCompression,
+		brotli:      true,
+		brotliLevel: brotli.DefaultCompression,
+	}
+	pools := c.encoderPools()
+
+	tests := []struct {
+		acceptEncoding   []string
+		expectedEncoding string
+		expectedIdentity bool
+	}{
+		{nil, "", true},
+		{[]string{""}, "", true},
+		{[]string{"gzip"}, "gzip", true},
+		{[]string{"GZIP"}, "gzip", true},
+		{[]string{"gzip, br"}, "br", true},
+		{[]string{"gzip", "br"}, "br", true},
+		{[]string{"gzip;q=0.5", "br;q=0.4"}, "gzip", true},
+		{[]string{"*"}, "br", true},
+		{[]string{"*;q=0.5, gzip"}, "gzip", true},
+		{[]string{"*, br;q=0"}, "gzip", true},
+		{[]string{"gzip;q=0, *"}, "br", true},
+		{[]string{"gzip;q=0, br;q=0, *"}, "", true},
+		{[]string{"identity"}, "", true},
+		{[]string{"gzip;q=0.5, identity"}, "", true},
+		{[]string{"gzip, identity;q=0.5"}, "gzip", true},
+		{[]string{"gzip, identity"}, "gzip", true},
+		{[]string{"gzip;q=0.5, *"}, "br", true},
+		{[]string{"gzip, identity;q=0"}, "gzip", false},
+		{[]string{"gzip, *;q=0"}, "gzip", false},
+		{[]string{"gzip, *;q=0, identity"}, "gzip", true},
+		{[]string{"identity;q=0"}, "", false},
+		{[]string{"compress, *;q=0"}, "", false},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		for _, v := range tt.acceptEncoding {
+			r.Header.Add("Accept-Encoding", v)
+		}
+
+		pool, identity := negotiateEncoding(r, pools)
+		encoding := ""
+		if pool != nil {
+			encoding = pool.name
+		}
+		assert.Equal(t, tt.expectedEncoding, encoding, "%q", tt.acceptEncoding)
+		assert.Equal(t, tt.expectedIdentity, identity, "%q", tt.acceptEncoding)
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	})
+
+	wrapper, _ := GzipHandlerWithOpts(NotAcceptable(true))
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "br, identity;q=0")
+	resp := httptest.NewRecorder()
+	wrapper(handler).ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, resp.Code)
+	assert.Equal(t, "Accept-Encoding", resp.Header().Get("Vary"))
+
+	// Without the option the response is served uncompressed.
+	wrapper, _ = GzipHandlerWithOpts()
+	resp = httptest.NewRecorder()
+	wrapper(handler).ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, testBody, resp.Body.String())
+}
+
+func TestIdentityNotAcceptableIgnoresMinSize(t *testing.T) {
+	handler := newTestHandler(smallTestBody)
+
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip, identity;q=0")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal(t, gzipStrLevel(smallTestBody, gzip.DefaultCompression), resp.Body.Bytes())
+}
@@ -0,0 +1,279 @@
+// Package negotiation implements parsing of the Accept-Encoding header and
+// content-coding negotiation as described in RFC 9110, section 12.5.3.
+//
+// It's the negotiation used by the gziphandler package, exposed for programs
+// which need to make the same decisions without compressing anything.
+package negotiation // import "github.com/NYTimes/gziphandler/negotiation"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultQValue is the qvalue of a content-coding listed without an
+	// explicit one.
+	DefaultQValue = 1.0
+
+	// Identity is the name of the identity coding, i.e. no compression.
+	Identity = "identity"
+
+	// Wildcard matches any content-coding not listed explicitly.
+	Wildcard = "*"
+)
+
+// Coding is a content-coding and its qvalue, as listed in an Accept-Encoding
+// header.
+type Coding struct {
+	// Name is the lower-cased name of the content-coding, e.g. "gzip", or
+	// Identity or Wildcard.
+	Name string
+
+	// QValue is the relative weight of the content-coding, between 0 and 1.
+	// Zero means "not acceptable".
+	QValue float64
+}
+
+// ParseError describes a malformed element of an Accept-Encoding header.
+type ParseError struct {
+	Index   int    // Index of the element in the comma-separated list, counting empty elements.
+	Offset  int    // Byte offset of the element in the parsed string.
+	Element string // The element, as it appears in the parsed string.
+	Err     error  // The reason the element is malformed.
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("element %d (%q) at offset %d: %v", e.Index, e.Element, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is the error returned by Parse, holding a ParseError for every
+// malformed element. It supports errors.As, which finds the first ParseError.
+type Errors []*ParseError
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return "errors while parsing encodings: " + strings.Join(s, ", ")
+}
+
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Parse parses a list of codings (content-codings with optional qvalues), as
+// might appear in an Accept-Encoding header, in the order they're listed.
+// Multiple header lines should be joined with commas before parsing.
+//
+// Parsing attempts to forgive minor formatting errors: elements with a
+// decimal qvalue which RFC 9110 doesn't allow, such as 1.5 or 0.1234, are
+// reported but still returned with the qvalue clamped to [0, 1]. Other
+// malformed elements, including those with qvalues such as NaN or 0x1p-1,
+// are reported and skipped. Empty
+// elements are ignored as RFC 9110 requires. If any element is malformed, the
+// error is of type Errors. It's probably safe to ignore, because silently
+// ignoring errors is how the internet works.
+func Parse(s string) ([]Coding, error) {
+	var (
+		codings []Coding
+		errs    Errors
+		offset  int
+	)
+
+	for i, element := range strings.Split(s, ",") {
+		if strings.TrimSpace(element) != "" {
+			coding, err := parseCoding(element)
+			if err != nil {
+				errs = append(errs, &ParseError{
+					Index:   i,
+					Offset:  offset,
+					Element: element,
+					Err:     err,
+				})
+			}
+			if coding.Name != "" {
+				codings = append(codings, coding)
+			}
+		}
+		offset += len(element) + 1
+	}
+
+	if len(errs) > 0 {
+		return codings, errs
+	}
+	return codings, nil
+}
+
+// parseCoding parses a single coding. If it returns both a named Coding and
+// an error, the Coding is usable despite the error.
+func parseCoding(s string) (Coding, error) {
+	parts := strings.Split(s, ";")
+	c := Coding{
+		Name:   strings.ToLower(strings.TrimSpace(parts[0])),
+		QValue: DefaultQValue,
+	}
+	if c.Name == "" {
+		return Coding{}, fmt.Errorf("empty content-coding")
+	}
+
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if !isDecimal(value) {
+			return Coding{}, fmt.Errorf("invalid qvalue %q", value)
+		}
+		// Too many digits make ParseFloat return an infinity, which clamps
+		// like any other out-of-range qvalue.
+		q, _ := strconv.ParseFloat(value, 64)
+		if q < 0.0 || q > 1.0 {
+			c.QValue = clamp(q)
+			return c, fmt.Errorf("qvalue %v out of range", q)
+		}
+		c.QValue = q
+		if !isQValue(value) {
+			return c, fmt.Errorf("malformed qvalue %q", value)
+		}
+	}
+	return c, nil
+}
+
+// isQValue returns whether s matches the qvalue grammar of RFC 9110:
+// "0" [ "." 0*3DIGIT ] / "1" [ "." 0*3("0") ].
+func isQValue(s string) bool {
+	whole, frac, _ := strings.Cut(s, ".")
+	if len(frac) > 3 {
+		return false
+	}
+	switch whole {
+	case "0":
+		return isDigits(frac)
+	case "1":
+		return strings.Trim(frac, "0") == ""
+	}
+	return false
+}
+
+// isDecimal returns whether s is a decimal number, possibly negative, which
+// strconv.ParseFloat parses the way a qvalue would be. It rules out what
+// ParseFloat accepts but RFC 9110 doesn't: NaN, infinities, exponents and
+// hexadecimal.
+func isDecimal(s string) bool {
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	return whole+frac != "" && isDigits(whole) && isDigits(frac)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func clamp(q float64) float64 {
+	if q < 0.0 {
+		return 0.0
+	}
+	if q > 1.0 {
+		return 1.0
+	}
+	return q
+}
+
+// Accepted holds the parsed codings of an Accept-Encoding header, indexed by
+// name for negotiation.
+type Accepted map[string]float64
+
+// NewAccepted indexes codings returned by Parse. If a content-coding is listed
+// more than once, the last qvalue wins.
+func NewAccepted(codings []Coding) Accepted {
+	a := make(Accepted, len(codings))
+	for _, c := range codings {
+		a[c.Name] = c.QValue
+	}
+	return a
+}
+
+// QValue returns the qvalue given to the named content-coding, falling back
+// to the wildcard's if it isn't listed. Zero means it isn't acceptable. name
+// must be lower-case.
+func (a Accepted) QValue(name string) float64 {
+	if q, ok := a[name]; ok {
+		return q
+	}
+	return a[Wildcard]
+}
+
+// Identity returns the qvalue of the identity coding, and whether it was
+// listed, either by name or through the wildcard. The identity coding is
+// always acceptable unless it's explicitly excluded, so an unlisted identity
+// is acceptable, but should only be chosen if nothing else is.
+func (a Accepted) Identity() (float64, bool) {
+	if q, ok := a[Identity]; ok {
+		return q, true
+	}
+	q, ok := a[Wildcard]
+	return q, ok
+}
+
+// Negotiate chooses between the supported content-codings and the identity
+// coding according to the given Accept-Encoding header value. supported
+// should be in order of the server's preference, which breaks ties between
+// content-codings with the same qvalue; ties with the identity coding are
+// broken in favour of the content-coding.
+//
+// It returns the chosen content-coding (lower-cased), or Identity, and
+// whether the choice is acceptable to the client at all. If it isn't, the
+// server should either respond with 406 Not Acceptable or ignore the header
+// and send an uncompressed response.
+//
+// An empty header means that only the identity coding is acceptable. Note
+// that this is not the same as no Accept-Encoding header at all, which per
+// RFC 9110 means that any content-coding is acceptable; callers must decide
+// what to do in that case themselves.
+func Negotiate(header string, supported []string) (string, bool) {
+	codings, _ := Parse(header)
+	return NewAccepted(codings).Negotiate(supported)
+}
+
+// Negotiate is like the package level Negotiate, but with an already parsed
+// header.
+func (a Accepted) Negotiate(supported []string) (string, bool) {
+	var (
+		best  = ""
+		bestQ = 0.0
+	)
+	for _, name := range supported {
+		name = strings.ToLower(name)
+		if name == Identity {
+			continue
+		}
+		if q := a.QValue(name); q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+
+	identityQ, listed := a.Identity()
+	if listed && identityQ > bestQ {
+		return Identity, true
+	}
+	if best != "" {
+		return best, true
+	}
+	return Identity, !listed || identityQ > 0
+}
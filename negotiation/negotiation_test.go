@@ -0,0 +1,105 @@
+package negotiation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	examples := map[string][]Coding{
+
+		// Examples from RFC 2616
+		"compress, gzip":                     {{"compress", 1.0}, {"gzip", 1.0}},
+		"":                                   nil,
+		"*":                                  {{"*", 1.0}},
+		"compress;q=0.5, gzip;q=1.0":         {{"compress", 0.5}, {"gzip", 1.0}},
+		"gzip;q=1.0, identity; q=0.5, *;q=0": {{"gzip", 1.0}, {"identity", 0.5}, {"*", 0.0}},
+
+		// More random stuff
+		"AAA;q=1":            {{"aaa", 1.0}},
+		"BBB ; q = 2":        {{"bbb", 1.0}},
+		"gzip;Q=0.5":         {{"gzip", 0.5}},
+		"gzip;q=0.5;foo=bar": {{"gzip", 0.5}},
+		"gzip, , br":         {{"gzip", 1.0}, {"br", 1.0}},
+		"br, gzip, br;q=0":   {{"br", 1.0}, {"gzip", 1.0}, {"br", 0.0}},
+	}
+
+	for eg, exp := range examples {
+		act, _ := Parse(eg)
+		assert.Equal(t, exp, act, eg)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	codings, err := Parse("gzip, ;q=1, br;q=abc, zstd;q=-1")
+	assert.Equal(t, []Coding{{"gzip", 1.0}, {"zstd", 0.0}}, codings)
+
+	var errs Errors
+	if !assert.True(t, errors.As(err, &errs)) {
+		return
+	}
+	if !assert.Len(t, errs, 3) {
+		return
+	}
+	assert.Equal(t, 1, errs[0].Index)
+	assert.Equal(t, 5, errs[0].Offset)
+	assert.Equal(t, " ;q=1", errs[0].Element)
+	assert.Equal(t, 2, errs[1].Index)
+	assert.Equal(t, 11, errs[1].Offset)
+	assert.Equal(t, " br;q=abc", errs[1].Element)
+	assert.Equal(t, 3, errs[2].Index)
+	assert.Equal(t, 21, errs[2].Offset)
+
+	var perr *ParseError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, errs[0], perr)
+
+	_, err = Parse("gzip, br;q=0.5")
+	assert.Nil(t, err)
+}
+
+func TestParseQValueGrammar(t *testing.T) {
+	codings, err := Parse("a;q=NaN, b;q=Inf, c;q=0x1p-1, d;q=5e-1, e;q=+0.5, f;q=0.1234, g;q=1.001, h;q=.5, i;q=0., j;q=1.000")
+	assert.Equal(t, []Coding{{"f", 0.1234}, {"g", 1.0}, {"h", 0.5}, {"i", 0.0}, {"j", 1.0}}, codings)
+
+	var errs Errors
+	if assert.True(t, errors.As(err, &errs)) && assert.Len(t, errs, 8) {
+		for i, element := range []string{"a", " b", " c", " d", " e", " f", " g", " h"} {
+			assert.Equal(t, element, errs[i].Element[:len(element)])
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"zstd", "br", "gzip"}
+
+	tests := []struct {
+		header     string
+		expected   string
+		acceptable bool
+	}{
+		{"", Identity, true},
+		{"gzip", "gzip", true},
+		{"gzip, br", "br", true},
+		{"gzip;q=0.5, br;q=0.4", "gzip", true},
+		{"*", "zstd", true},
+		{"*, zstd;q=0", "br", true},
+		{"gzip;q=0.5, identity", Identity, true},
+		{"gzip, identity;q=0", "gzip", true},
+		{"deflate", Identity, true},
+		{"deflate, identity;q=0", Identity, false},
+		{"deflate, *;q=0", Identity, false},
+		{"deflate, *;q=0, identity;q=0.1", Identity, true},
+	}
+
+	for _, tt := range tests {
+		coding, ok := Negotiate(tt.header, supported)
+		assert.Equal(t, tt.expected, coding, tt.header)
+		assert.Equal(t, tt.acceptable, ok, tt.header)
+	}
+
+	coding, _ := Negotiate("gzip, br", []string{"GZIP", "br"})
+	assert.Equal(t, "gzip", coding)
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package gziphandler
+
+const raceEnabled = false
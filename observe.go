@@ -0,0 +1,279 @@
+package gziphandler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Reason says why the handler did, or didn't, compress a response.
+type Reason int
+
+const (
+	// ReasonUnknown is the Reason of a response still being written.
+	ReasonUnknown Reason = iota
+
+	// ReasonCompressed means that the response was compressed.
+	ReasonCompressed
+
+	// ReasonCached means that the response was served compressed from the
+	// ResponseCache.
+	ReasonCached
+
+	// ReasonNotAccepted means that the client prefers, or only accepts,
+	// uncompressed responses.
+	ReasonNotAccepted
+
+	// ReasonNotAcceptable means that the client accepts none of the enabled
+	// content-codings, nor uncompressed responses, and was sent a 406 Not
+	// Acceptable response, as configured with NotAcceptable.
+	ReasonNotAcceptable
+
+	// ReasonRange means that the request was a Range request, as described
+	// by RangeSkipCompression.
+	ReasonRange
+
+	// ReasonAlreadyEncoded means that the handler set a Content-Encoding.
+	ReasonAlreadyEncoded
+
+	// ReasonOptedOut means that the handler set a Cache-Control:
+	// no-transform header, or called DisableCompression.
+	ReasonOptedOut
+
+	// ReasonNoBody means that the response had no body, because of its
+	// status code or because the handler didn't write one.
+	ReasonNoBody
+
+	// ReasonPartialContent means that the response was a 206 Partial Content
+	// one, or had a Content-Range header.
+	ReasonPartialContent
+
+	// ReasonTooSmall means that the response was smaller than MinSize.
+	ReasonTooSmall
+
+	// ReasonContentType means that the Content-Type of the response was
+	// excluded by ContentTypes or ExcludeContentTypes.
+	ReasonContentType
+)
+
+var reasonNames = [...]string{
+	ReasonUnknown:        "unknown",
+	ReasonCompressed:     "compressed",
+	ReasonCached:         "cached",
+	ReasonNotAccepted:    "not_accepted",
+	ReasonNotAcceptable:  "not_acceptable",
+	ReasonRange:          "range",
+	ReasonAlreadyEncoded: "already_encoded",
+	ReasonOptedOut:       "opted_out",
+	ReasonNoBody:         "no_body",
+	ReasonPartialContent: "partial_content",
+	ReasonTooSmall:       "too_small",
+	ReasonContentType:    "content_type",
+}
+
+func (r Reason) String() string {
+	if r >= 0 && int(r) < len(reasonNames) {
+		return reasonNames[r]
+	}
+	return fmt.Sprintf("Reason(%d)", int(r))
+}
+
+// Decision records what the handler did with a response, and why.
+type Decision struct {
+	Request *http.Request
+	Status  int // The status code of the response.
+	Reason  Reason
+
+	// Encoding is the content-coding the response was compressed with, or ""
+	// if it wasn't compressed.
+	Encoding string
+
+	// Level is the compression level of the built-in encoders, e.g. that set
+	// with CompressionLevel for gzip, and 0 for other Encoders.
+	Level int
+
+	// UncompressedBytes counts the bytes written by the handler.
+	UncompressedBytes int64
+
+	// CompressedBytes counts the bytes of the body actually sent, which is
+	// UncompressedBytes for an uncompressed response, and none for the
+	// response to a HEAD request.
+	CompressedBytes int64
+
+	// CPUTime is the time spent compressing the response, not counting the
+	// time spent writing it to the client.
+	CPUTime time.Duration
+}
+
+// Compressed returns whether the response was compressed.
+func (d Decision) Compressed() bool {
+	return d.Encoding != ""
+}
+
+// Observer sets a function which the handler calls with the Decision it made
+// for each response, once the response is complete, i.e. after the wrapped
+// handler has returned and the GzipResponseWriter is closed. It's called from
+// the goroutine serving the request, so it should be quick.
+func Observer(observe func(Decision)) option {
+	return scoped("Observer", compressing, func(c *config) {
+		c.observers = append(c.observers, observe)
+	})
+}
+
+// observe returns the function calling all the observers of c, or nil if
+// there are none.
+func (c *config) observe() func(Decision) {
+	if len(c.observers) == 0 {
+		return nil
+	}
+	observers := c.observers
+	return func(d Decision) {
+		for _, o := range observers {
+			o(d)
+		}
+	}
+}
+
+// leveledFactory is implemented by the built-in EncoderFactories, to report
+// their compression level in Decisions.
+type leveledFactory interface {
+	compressionLevel() int
+}
+
+// factoryLevel returns the compression level of the Encoders created by f, or
+// 0 if it isn't a built-in EncoderFactory.
+func factoryLevel(f EncoderFactory) int {
+	if lf, ok := f.(leveledFactory); ok {
+		return lf.compressionLevel()
+	}
+	return 0
+}
+
+// Decision returns the Decision made for the response so far. Its Reason is
+// ReasonUnknown until the decision to compress or not is made, and its byte
+// counts are only final once the GzipResponseWriter is closed.
+func (w *GzipResponseWriter) Decision() Decision {
+	d := w.decision
+	if d.Status == 0 {
+		d.Status = http.StatusOK
+	}
+	if d.Encoding == "" {
+		d.CompressedBytes = d.UncompressedBytes
+	} else if w.dst != nil {
+		d.CompressedBytes = w.dst.n
+	}
+	return d
+}
+
+// meteredWriter counts the compressed bytes written to the response, and the
+// time spent writing them if timed.
+type meteredWriter struct {
+	io.Writer
+	n     int64
+	timed bool
+	dur   time.Duration
+}
+
+func (m *meteredWriter) Write(b []byte) (int, error) {
+	var start time.Time
+	if m.timed {
+		start = time.Now()
+	}
+	n, err := m.Writer.Write(b)
+	m.n += int64(n)
+	if m.timed {
+		m.dur += time.Since(start)
+	}
+	return n, err
+}
+
+// encode calls f, which uses the Encoder, and adds the time it took, less
+// that spent writing to the response, to the CPUTime of the decision, if it's
+// needed by an Observer or ServerTiming.
+func (w *GzipResponseWriter) encode(f func() error) error {
+	if !w.dst.timed {
+		return f()
+	}
+	start, written := time.Now(), w.dst.dur
+	err := f()
+	w.decision.CPUTime += time.Since(start) - (w.dst.dur - written)
+	return err
+}
+
+// observedWriter records the status code and size of responses which go
+// through the handler without a GzipResponseWriter, for the Observer.
+type observedWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *observedWriter) WriteHeader(code int) {
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *observedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *observedWriter) Flush() {
+	if fw, ok := w.ResponseWriter.(http.Flusher); ok {
+		fw.Flush()
+	}
+}
+
+func (w *observedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("http.Hijacker interface is not supported")
+}
+
+func (w *observedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Push implements http.Pusher. It returns http.ErrNotSupported if the
+// underlying ResponseWriter isn't an http.Pusher.
+func (w *observedWriter) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// observedWriterWithCloseNotify is an observedWriter which is also an
+// http.CloseNotifier, used when the underlying ResponseWriter is one.
+type observedWriterWithCloseNotify struct {
+	*observedWriter
+}
+
+func (w observedWriterWithCloseNotify) CloseNotify() <-chan bool {
+	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// decision returns the Decision for the response.
+func (w *observedWriter) decision(r *http.Request, reason Reason) Decision {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return Decision{
+		Request:           r,
+		Status:            status,
+		Reason:            reason,
+		UncompressedBytes: w.n,
+		CompressedBytes:   w.n,
+	}
+}
@@ -0,0 +1,199 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserver(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		expected       Decision
+	}{
+		{"compressed", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody[:1000])
+			io.WriteString(w, testBody[1000:])
+		}, Decision{Status: 200, Reason: ReasonCompressed, Encoding: "gzip", Level: gzip.DefaultCompression}},
+		{"not accepted", "identity", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, testBody)
+		}, Decision{Status: 201, Reason: ReasonNotAccepted}},
+		{"not acceptable", "br, identity;q=0", func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler called")
+		}, Decision{Status: 406, Reason: ReasonNotAcceptable}},
+		{"range", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader(testBody))
+		}, Decision{Status: 206, Reason: ReasonRange}},
+		{"already encoded", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, testBody)
+		}, Decision{Status: 200, Reason: ReasonAlreadyEncoded}},
+		{"opted out", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			DisableCompression(w)
+			io.WriteString(w, testBody)
+		}, Decision{Status: 200, Reason: ReasonOptedOut}},
+		{"no body", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, Decision{Status: 204, Reason: ReasonNoBody}},
+		{"empty body", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		}, Decision{Status: 200, Reason: ReasonNoBody}},
+		{"partial content", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-99/1000")
+			io.WriteString(w, testBody)
+		}, Decision{Status: 200, Reason: ReasonPartialContent}},
+		{"too small", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, smallTestBody)
+		}, Decision{Status: 200, Reason: ReasonTooSmall}},
+		{"too small by Content-Length", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "10")
+			io.WriteString(w, testBody[:10])
+		}, Decision{Status: 200, Reason: ReasonTooSmall}},
+		{"content type", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, testBody)
+		}, Decision{Status: 200, Reason: ReasonContentType}},
+		{"sniffed content type", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "\x89PNG\x0d\x0a\x1a\x0a"+testBody)
+		}, Decision{Status: 200, Reason: ReasonContentType}},
+	}
+
+	for _, tt := range tests {
+		var decisions []Decision
+		wrapper, err := GzipHandlerWithOpts(
+			Observer(func(d Decision) { decisions = append(decisions, d) }),
+			ExcludeContentTypes([]string{"image/png"}),
+			NotAcceptable(true),
+		)
+		assert.Nil(t, err)
+		handler := wrapper(tt.handler)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		if tt.expected.Reason == ReasonRange {
+			req.Header.Set("Range", "bytes=0-99")
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if !assert.Len(t, decisions, 1, tt.name) {
+			continue
+		}
+		d := decisions[0]
+		assert.Equal(t, "/", d.Request.URL.Path, tt.name)
+		assert.Equal(t, tt.expected.Status, d.Status, tt.name)
+		assert.Equal(t, tt.expected.Reason, d.Reason, tt.name)
+		assert.Equal(t, tt.expected.Encoding, d.Encoding, tt.name)
+		assert.Equal(t, tt.expected.Level, d.Level, tt.name)
+		assert.Equal(t, tt.expected.Encoding != "", d.Compressed(), tt.name)
+		assert.Equal(t, int64(resp.Body.Len()), d.CompressedBytes, tt.name)
+		if d.Compressed() {
+			assert.Equal(t, int64(len(testBody)), d.UncompressedBytes, tt.name)
+			assert.True(t, d.CPUTime > 0, tt.name)
+		} else {
+			assert.Equal(t, d.CompressedBytes, d.UncompressedBytes, tt.name)
+			assert.Zero(t, d.CPUTime, tt.name)
+		}
+	}
+}
+
+func TestObserverCachedAndHead(t *testing.T) {
+	var decisions []Decision
+	cache := NewResponseCache(1 << 20)
+	wrapper, _ := GzipHandlerWithOpts(
+		Observer(func(d Decision) { decisions = append(decisions, d) }),
+		CacheResponses(cache),
+		BrotliLevel(5),
+	)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"a"`)
+		io.WriteString(w, testBody)
+	}))
+
+	for _, method := range []string{"GET", "GET", "HEAD"} {
+		req := httptest.NewRequest(method, "/", nil)
+		req.Header.Set("Accept-Encoding", "br")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+	}
+
+	if assert.Len(t, decisions, 3) {
+		assert.Equal(t, ReasonCompressed, decisions[0].Reason)
+		assert.Equal(t, ReasonCached, decisions[1].Reason)
+		assert.Equal(t, ReasonCompressed, decisions[2].Reason)
+		for _, d := range decisions {
+			assert.Equal(t, "br", d.Encoding)
+			assert.Equal(t, 5, d.Level)
+			assert.Equal(t, int64(len(testBody)), d.UncompressedBytes)
+		}
+		assert.Equal(t, decisions[0].CompressedBytes, decisions[1].CompressedBytes)
+		assert.Zero(t, decisions[1].CPUTime)
+		assert.Zero(t, decisions[2].CompressedBytes)
+	}
+}
+
+// pushCloseNotifyRecorder is a ResponseRecorder which is also an
+// http.Pusher and an http.CloseNotifier.
+type pushCloseNotifyRecorder struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (r *pushCloseNotifyRecorder) Push(target string, opts *http.PushOptions) error {
+	r.pushed = append(r.pushed, target)
+	return nil
+}
+
+func (r *pushCloseNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestObserverKeepsInterfaces(t *testing.T) {
+	var decisions []Decision
+	wrapper, _ := GzipHandlerWithOpts(Observer(func(d Decision) {
+		decisions = append(decisions, d)
+	}))
+
+	var closeNotifier bool
+	var pushErr error
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, closeNotifier = w.(http.CloseNotifier)
+		pushErr = w.(http.Pusher).Push("/style.css", nil)
+		io.WriteString(w, testBody)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rec := &pushCloseNotifyRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(rec, req)
+	assert.True(t, closeNotifier)
+	assert.Nil(t, pushErr)
+	assert.Equal(t, []string{"/style.css"}, rec.pushed)
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, closeNotifier)
+	assert.Equal(t, http.ErrNotSupported, pushErr)
+
+	if assert.Len(t, decisions, 2) {
+		assert.Equal(t, ReasonNotAccepted, decisions[0].Reason)
+		assert.Equal(t, int64(len(testBody)), decisions[0].UncompressedBytes)
+	}
+}
+
+func TestObserverMustNotBeNil(t *testing.T) {
+	_, err := GzipHandlerWithOpts(Observer(nil))
+	assert.Error(t, err)
+}
+
+func TestReasonString(t *testing.T) {
+	assert.Equal(t, "compressed", ReasonCompressed.String())
+	assert.Equal(t, "content_type", ReasonContentType.String())
+	assert.Equal(t, "Reason(42)", Reason(42).String())
+}
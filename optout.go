@@ -0,0 +1,46 @@
+package gziphandler
+
+import (
+	"net/http"
+)
+
+// DisableCompression stops the handler from compressing the response being
+// written to w, e.g. because it's streaming media or an encrypted payload
+// which wouldn't compress anyway. w is the http.ResponseWriter passed to the
+// handler wrapped by GzipHandler, or one wrapping it and implementing
+// Unwrap() http.ResponseWriter, like those understood by
+// http.ResponseController.
+//
+// It must be called before the response starts being written. It returns
+// false if it was called too late, i.e. once the header or the first part of
+// the body was sent, whether compressed or not, or if w doesn't come from
+// this package.
+func DisableCompression(w http.ResponseWriter) bool {
+	for {
+		switch rw := w.(type) {
+		case *GzipResponseWriter:
+			return rw.disableCompression()
+		case GzipResponseWriterWithCloseNotify:
+			return rw.disableCompression()
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+func (w *GzipResponseWriter) disableCompression() bool {
+	if w.gw != nil || w.ignore || w.discard || w.Header().Get(contentEncoding) != "" {
+		return false
+	}
+	w.disabled = true
+	return true
+}
+
+// optedOut returns whether the handler asked for the response not to be
+// compressed, with DisableCompression or a Cache-Control: no-transform header,
+// which forbids changing its Content-Encoding.
+func (w *GzipResponseWriter) optedOut() bool {
+	return w.disabled || hasCacheDirective(w.Header(), "no-transform")
+}
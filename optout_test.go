@@ -0,0 +1,85 @@
+package gziphandler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoTransform(t *testing.T) {
+	for _, cc := range []string{"no-transform", "public, No-Transform", "max-age=60,no-transform"} {
+		handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", cc)
+			io.WriteString(w, testBody)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Empty(t, resp.Header().Get("Content-Encoding"), cc)
+		assert.Equal(t, testBody, resp.Body.String(), cc)
+	}
+}
+
+// unwrappingResponseWriter is another middleware's http.ResponseWriter.
+type unwrappingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w unwrappingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestDisableCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected bool
+	}{
+		{"before writing", func(w http.ResponseWriter, r *http.Request) {
+			assert.True(t, DisableCompression(w))
+			io.WriteString(w, testBody)
+		}, false},
+		{"while buffering", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody[:10])
+			assert.True(t, DisableCompression(w))
+			io.WriteString(w, testBody[10:])
+		}, false},
+		{"wrapped", func(w http.ResponseWriter, r *http.Request) {
+			assert.True(t, DisableCompression(unwrappingResponseWriter{w}))
+			io.WriteString(w, testBody)
+		}, false},
+		{"too late", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, testBody)
+			assert.False(t, DisableCompression(w))
+		}, true},
+		{"too late, uncompressed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "no-transform")
+			io.WriteString(w, testBody)
+			assert.False(t, DisableCompression(w))
+		}, false},
+	}
+
+	for _, tt := range tests {
+		handler := GzipHandler(tt.handler)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		if tt.expected {
+			assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"), tt.name)
+		} else {
+			assert.Empty(t, resp.Header().Get("Content-Encoding"), tt.name)
+			assert.Equal(t, testBody, resp.Body.String(), tt.name)
+		}
+	}
+
+	assert.False(t, DisableCompression(httptest.NewRecorder()))
+}
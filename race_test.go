@@ -0,0 +1,7 @@
+//go:build race
+
+package gziphandler
+
+// raceEnabled is whether the tests run under the race detector, which makes
+// sync.Pool drop items at random.
+const raceEnabled = true
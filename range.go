@@ -0,0 +1,60 @@
+package gziphandler
+
+import (
+	"net/http"
+)
+
+const (
+	acceptRanges = "Accept-Ranges"
+	contentRange = "Content-Range"
+	rangeHeader  = "Range"
+	ifRange      = "If-Range"
+)
+
+// RangePolicy says how the handler deals with Range requests. The byte ranges
+// of a Range request, and the Content-Range of a 206 Partial Content
+// response, refer to the uncompressed representation, so compressing the
+// partial response the handler serves would corrupt resumed downloads.
+type RangePolicy int
+
+const (
+	// RangeSkipCompression serves Range requests uncompressed. This is the
+	// default.
+	RangeSkipCompression RangePolicy = iota
+
+	// RangeCompressFull removes the Range and If-Range headers of every
+	// request from a client accepting compressed responses, before the
+	// response is known, so the handler serves the full representation. If
+	// it's compressed, the Accept-Ranges header is removed from it to
+	// discourage further Range requests. If it isn't, e.g. because its
+	// Content-Type is excluded, it's served in full with a 200 status, which
+	// makes resuming downloads start over, so RangeSkipCompression is better
+	// suited to handlers serving large incompressible files.
+	RangeCompressFull
+)
+
+// Ranges sets how the handler deals with Range requests. Whatever the policy,
+// 206 Partial Content responses, and any with a Content-Range header, are
+// never compressed.
+func Ranges(policy RangePolicy) option {
+	return scoped("Ranges", compressing, func(c *config) {
+		c.rangePolicy = policy
+	})
+}
+
+// withoutRange returns a shallow copy of r without the Range and If-Range
+// headers.
+func withoutRange(r *http.Request) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = r.Header.Clone()
+	r2.Header.Del(rangeHeader)
+	r2.Header.Del(ifRange)
+	return r2
+}
+
+// partial returns whether the response is a partial one, i.e. a 206 or one
+// with a Content-Range.
+func (w *GzipResponseWriter) partial() bool {
+	return w.code == http.StatusPartialContent || w.Header().Get(contentRange) != ""
+}
@@ -0,0 +1,135 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRanges(t *testing.T) {
+	tests := []struct {
+		policy           RangePolicy
+		acceptEncoding   string
+		expectedCode     int
+		expectedEncoding string
+		expectedBody     string
+	}{
+		{RangeSkipCompression, "gzip", http.StatusPartialContent, "", testBody[:100]},
+		{RangeSkipCompression, "", http.StatusPartialContent, "", testBody[:100]},
+		{RangeCompressFull, "gzip", http.StatusOK, "gzip", string(gzipStrLevel(testBody, gzip.DefaultCompression))},
+		{RangeCompressFull, "", http.StatusPartialContent, "", testBody[:100]},
+	}
+
+	for _, tt := range tests {
+		wrapper, err := GzipHandlerWithOpts(Ranges(tt.policy))
+		assert.Nil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(testBody))
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		req.Header.Set("Range", "bytes=0-99")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, tt.expectedCode, resp.Code, tt.policy, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedEncoding, resp.Header().Get("Content-Encoding"), tt.policy, tt.acceptEncoding)
+		assert.Equal(t, tt.expectedBody, resp.Body.String(), tt.policy, tt.acceptEncoding)
+		if tt.expectedEncoding != "" {
+			assert.Empty(t, resp.Header().Get("Accept-Ranges"))
+			assert.Empty(t, resp.Header().Get("Content-Range"))
+		}
+		// The handler's request is a copy.
+		assert.Equal(t, "bytes=0-99", req.Header.Get("Range"))
+	}
+
+	_, err := GzipHandlerWithOpts(Ranges(RangePolicy(42)))
+	assert.Error(t, err)
+}
+
+func TestRangeCompressFullIncompressible(t *testing.T) {
+	body := strings.Repeat("not really a video ", 100)
+	for _, policy := range []RangePolicy{RangeSkipCompression, RangeCompressFull} {
+		wrapper, _ := GzipHandlerWithOpts(Ranges(policy), ExcludeContentTypes([]string{"video/*"}))
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "test.mp4", time.Time{}, strings.NewReader(body))
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("Range", "bytes=100-")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, "", resp.Header().Get("Content-Encoding"), policy)
+		assert.Equal(t, "bytes", resp.Header().Get("Accept-Ranges"), policy)
+		if policy == RangeCompressFull {
+			// The Range was removed before the response was known to be
+			// incompressible, so the full body is served.
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, body, resp.Body.String())
+		} else {
+			assert.Equal(t, http.StatusPartialContent, resp.Code)
+			assert.Equal(t, body[100:], resp.Body.String())
+		}
+	}
+}
+
+func TestRangesAcceptRanges(t *testing.T) {
+	for _, policy := range []RangePolicy{RangeSkipCompression, RangeCompressFull} {
+		wrapper, _ := GzipHandlerWithOpts(Ranges(policy))
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "test.txt", time.Time{}, strings.NewReader(testBody))
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+		if policy == RangeCompressFull {
+			assert.Empty(t, resp.Header().Get("Accept-Ranges"))
+		} else {
+			assert.Equal(t, "bytes", resp.Header().Get("Accept-Ranges"))
+		}
+	}
+}
+
+func TestPartialResponsesAreNotCompressed(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"206", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, testBody)
+		}},
+		{"Content-Range", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-99/1000")
+			io.WriteString(w, testBody)
+		}},
+	}
+
+	for _, tt := range tests {
+		for _, policy := range []RangePolicy{RangeSkipCompression, RangeCompressFull} {
+			wrapper, _ := GzipHandlerWithOpts(Ranges(policy))
+			handler := wrapper(tt.handler)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			assert.Empty(t, resp.Header().Get("Content-Encoding"), tt.name)
+			assert.Equal(t, testBody, resp.Body.String(), tt.name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package gziphandler
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+const xContentTypeOptions = "X-Content-Type-Options"
+
+// sniffLen is the number of bytes DetectContentType considers, as for
+// http.DetectContentType.
+const sniffLen = 512
+
+// magicNumbers maps the signatures of compressed formats missing from
+// http.DetectContentType, or which it names differently, to their content
+// types.
+var magicNumbers = []struct {
+	sig         []byte
+	contentType string
+}{
+	{[]byte("\x1f\x8b\x08"), "application/gzip"},
+	{[]byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{[]byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{[]byte("BZh"), "application/x-bzip2"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{[]byte("\x00asm"), "application/wasm"},
+}
+
+// jsPrefixes and cssPrefixes are the beginnings of typical JavaScript and CSS
+// files, after any leading comments.
+var (
+	jsPrefixes = []string{
+		`"use strict"`, `'use strict'`, "function", "(function", "!function",
+		"(()", "(async", "var ", "let ", "const ", "import ", "import{",
+		"export ", "export{", "class ", "window.", "document.", "self.",
+	}
+	cssPrefixes = []string{
+		"@charset", "@import", "@media", "@font-face", "@keyframes", "@layer",
+		"@supports", "@namespace", ":root", "*{", "* {", "html{", "html {",
+		"body{", "body {",
+	}
+)
+
+// DetectContentType implements the algorithm of http.DetectContentType to
+// determine the Content-Type of the given data, and improves on it for the
+// formats most often served compressed, or not: it recognises JSON,
+// JavaScript, CSS and SVG, which http.DetectContentType would consider plain
+// text or XML, as well as WebAssembly and the common compressed formats.
+//
+// It's what the handler uses to guess the Content-Type of responses without
+// one, unless configured otherwise with Sniffer.
+func DetectContentType(data []byte) string {
+	if len(data) > sniffLen {
+		data = data[:sniffLen]
+	}
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(data, m.sig) {
+			return m.contentType
+		}
+	}
+
+	ct := http.DetectContentType(data)
+	if !strings.HasPrefix(ct, "text/plain") && !strings.HasPrefix(ct, "text/xml") {
+		return ct
+	}
+
+	text := trimComments(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case isJSON(text):
+		return "application/json"
+	case bytes.Contains(data, []byte("<svg")) && (bytes.HasPrefix(text, []byte("<svg")) || bytes.HasPrefix(text, []byte("<?xml"))):
+		return "image/svg+xml"
+	case hasAnyPrefix(text, jsPrefixes):
+		return "text/javascript; charset=utf-8"
+	case hasAnyPrefix(text, cssPrefixes):
+		return "text/css; charset=utf-8"
+	}
+	return ct
+}
+
+// trimComments returns data without leading white space and /* */ or //
+// comments.
+func trimComments(data []byte) []byte {
+	for {
+		data = bytes.TrimLeft(data, " \t\r\n")
+		switch {
+		case bytes.HasPrefix(data, []byte("/*")):
+			i := bytes.Index(data[2:], []byte("*/"))
+			if i < 0 {
+				return nil
+			}
+			data = data[i+4:]
+		case bytes.HasPrefix(data, []byte("//")):
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				return nil
+			}
+			data = data[i+1:]
+		default:
+			return data
+		}
+	}
+}
+
+// isJSON returns whether data looks like the beginning of a JSON object or
+// array. Strings, numbers and literals at the top level are too ambiguous.
+func isJSON(data []byte) bool {
+	if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+		return false
+	}
+	rest := bytes.TrimLeft(data[1:], " \t\r\n")
+	if len(rest) == 0 {
+		return true
+	}
+	if data[0] == '{' {
+		return rest[0] == '"' || rest[0] == '}'
+	}
+	return strings.IndexByte(`{["-0123456789tfn]`, rest[0]) >= 0
+}
+
+func hasAnyPrefix(data []byte, prefixes []string) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(data, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sniffer sets the function used to guess the Content-Type of responses
+// without one, from their first bytes, instead of DetectContentType. It
+// returns a Content-Type, which is matched against ContentTypes and
+// ExcludeContentTypes, and set on the response unless the handler set the
+// X-Content-Type-Options: nosniff header.
+func Sniffer(sniff func(data []byte) string) option {
+	return scoped("Sniffer", compressing, func(c *config) {
+		c.sniff = sniff
+	})
+}
+
+// nosniff returns whether the header forbids guessing the Content-Type.
+func nosniff(h http.Header) bool {
+	return strings.EqualFold(strings.TrimSpace(h.Get(xContentTypeOptions)), "nosniff")
+}
@@ -0,0 +1,116 @@
+package gziphandler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{`{"a": 1}`, "application/json"},
+		{"\xef\xbb\xbf  {}", "application/json"},
+		{"[\n  {\"a\": 1}]", "application/json"},
+		{"[1, 2]", "application/json"},
+		{"[]", "application/json"},
+		{"{ not json", "text/plain; charset=utf-8"},
+		{"[link](http://example.com)", "text/plain; charset=utf-8"},
+		{`"use strict";var a=1`, "text/javascript; charset=utf-8"},
+		{"/*! license */\n(function(){})()", "text/javascript; charset=utf-8"},
+		{"// comment\nimport x from './x.js'", "text/javascript; charset=utf-8"},
+		{"export const a = 1", "text/javascript; charset=utf-8"},
+		{"@charset \"utf-8\";", "text/css; charset=utf-8"},
+		{"/* reset */ :root{--a:1}", "text/css; charset=utf-8"},
+		{"body { margin: 0 }", "text/css; charset=utf-8"},
+		{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, "image/svg+xml"},
+		{`<?xml version="1.0"?><svg></svg>`, "image/svg+xml"},
+		{`<?xml version="1.0"?><feed></feed>`, "text/xml; charset=utf-8"},
+		{"<html><svg></svg></html>", "text/html; charset=utf-8"},
+		{"\x00asm\x01\x00\x00\x00", "application/wasm"},
+		{"\x1f\x8b\x08\x00\x00\x00\x00\x00", "application/gzip"},
+		{"\x28\xb5\x2f\xfd\x00", "application/zstd"},
+		{"PK\x03\x04\x14\x00", "application/zip"},
+		{"\x89PNG\x0d\x0a\x1a\x0a", "image/png"},
+		{"RIFF\x00\x00\x00\x00WEBPVP", "image/webp"},
+		{"\xfd7zXZ\x00\x00", "application/x-xz"},
+		{"plain text", "text/plain; charset=utf-8"},
+		{"", "text/plain; charset=utf-8"},
+		{"/* unterminated", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, DetectContentType([]byte(tt.data)), tt.data)
+	}
+}
+
+func TestSniffer(t *testing.T) {
+	var sniffed []byte
+	wrapper, err := GzipHandlerWithOpts(Sniffer(func(data []byte) string {
+		sniffed = data
+		return "application/x-custom"
+	}), ContentTypes([]string{"application/x-custom"}))
+	assert.Nil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, testBody, string(sniffed))
+	assert.Equal(t, "application/x-custom", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+
+	_, err = GzipHandlerWithOpts(Sniffer(nil))
+	assert.Error(t, err)
+}
+
+func TestSnifferJSON(t *testing.T) {
+	body := `{"items": [` + strings.Repeat(`"aaabbbccc", `, 200) + `"x"]}`
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+}
+
+func TestSnifferNosniff(t *testing.T) {
+	for _, png := range []bool{false, true} {
+		wrapper, _ := GzipHandlerWithOpts(ExcludeContentTypes(IncompressibleContentTypes))
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if png {
+				io.WriteString(w, "\x89PNG\x0d\x0a\x1a\x0a")
+			}
+			io.WriteString(w, testBody)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		// The guess still decides whether to compress, but isn't sent.
+		assert.Empty(t, resp.Header().Get("Content-Type"))
+		if png {
+			assert.Empty(t, resp.Header().Get("Content-Encoding"))
+		} else {
+			assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package gziphandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	serverTiming               = "Server-Timing"
+	xUncompressedContentLength = "X-Uncompressed-Content-Length"
+	xCompressionRatio          = "X-Compression-Ratio"
+	trailer                    = "Trailer"
+	timingTrailers             = serverTiming + ", " + xUncompressedContentLength + ", " + xCompressionRatio
+)
+
+// heldResponseLimit is the size of compressed response ServerTiming holds
+// back before streaming it.
+const heldResponseLimit = 1 << 20
+
+// ServerTiming makes the handler describe what it did with the responses to
+// the requests for which enabled returns true, for debugging. It adds a
+// Server-Timing entry such as:
+//
+//	Server-Timing: compress;dur=0.412;desc="gzip-6"
+//
+// giving the time spent compressing in milliseconds, and the content-coding
+// and level used, along with X-Uncompressed-Content-Length and
+// X-Compression-Ratio headers. Uncompressed responses get an entry without a
+// duration, whose description is the Reason they weren't compressed, and
+// those served from a ResponseCache, or to HEAD requests, one with a zero
+// duration.
+//
+// Since these are only known once the response is complete, the handler
+// holds back compressed responses until then, and sends them with a
+// Content-Length. If the handler flushes the response, or it grows beyond
+// 1MiB, it's streamed instead, and the headers are sent as trailers.
+//
+// enabled is called for every request, so it should be quick, and it should
+// keep the debug mode from most traffic, e.g. by checking a secret in a
+// request header or query parameter:
+//
+//	ServerTiming(func(r *http.Request) bool {
+//		return r.Header.Get("X-Debug-Compression") == debugToken
+//	})
+func ServerTiming(enabled func(r *http.Request) bool) option {
+	return scoped("ServerTiming", compressing, func(c *config) {
+		c.serverTiming = enabled
+	})
+}
+
+// timingEntry returns the Server-Timing entry describing d.
+func timingEntry(d Decision) string {
+	if !d.Compressed() {
+		return `compress;desc="` + d.Reason.String() + `"`
+	}
+	level := d.Level
+	if level == gzip.DefaultCompression && (d.Encoding == "gzip" || d.Encoding == "deflate") {
+		level = 6 // The level compress/flate uses by default.
+	}
+	desc := d.Encoding
+	if level != 0 {
+		desc += "-" + strconv.Itoa(level)
+	}
+	switch {
+	case d.Reason == ReasonCached:
+		return `compress;dur=0;desc="` + desc + ` cached"`
+	case d.Request != nil && d.Request.Method == http.MethodHead:
+		return `compress;dur=0;desc="` + desc + `"`
+	}
+	ms := float64(d.CPUTime) / float64(time.Millisecond)
+	return "compress;dur=" + strconv.FormatFloat(ms, 'f', 3, 64) + `;desc="` + desc + `"`
+}
+
+// setTimingHeaders sets the Server-Timing and debug headers of the compressed
+// response in h, adding to any Server-Timing entries of the handler unless
+// they were already sent and h holds the trailers.
+func (w *GzipResponseWriter) setTimingHeaders(h http.Header, trailers bool) {
+	d := w.Decision()
+	if trailers {
+		h.Set(serverTiming, timingEntry(d))
+	} else {
+		h.Add(serverTiming, timingEntry(d))
+	}
+	h.Set(xUncompressedContentLength, strconv.FormatInt(d.UncompressedBytes, 10))
+	if d.UncompressedBytes > 0 {
+		ratio := float64(d.CompressedBytes) / float64(d.UncompressedBytes)
+		h.Set(xCompressionRatio, strconv.FormatFloat(ratio, 'f', 4, 64))
+	}
+}
+
+// heldWriter holds back the compressed response, and its header, until it's
+// complete and the Server-Timing header can be set, or until it's streamed.
+type heldWriter struct {
+	w         *GzipResponseWriter
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (h *heldWriter) Write(b []byte) (int, error) {
+	if h.streaming {
+		return h.w.ResponseWriter.Write(b)
+	}
+	h.buf.Write(b)
+	if h.buf.Len() > heldResponseLimit {
+		if err := h.stream(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// stream announces the trailers, and writes the header and what's been held
+// back of the response. Later writes go straight to the response.
+func (h *heldWriter) stream() error {
+	if h.streaming {
+		return nil
+	}
+	h.streaming = true
+	h.w.Header().Add(trailer, timingTrailers)
+	h.w.writeHeldHeader()
+	_, err := h.w.ResponseWriter.Write(h.buf.Bytes())
+	h.buf = bytes.Buffer{}
+	return err
+}
+
+// finish sets the Server-Timing and debug headers, or trailers if the response
+// was streamed, and writes the rest of the response.
+func (h *heldWriter) finish() error {
+	if h.streaming {
+		h.w.setTimingHeaders(h.w.Header(), true)
+		return nil
+	}
+	h.w.setTimingHeaders(h.w.Header(), false)
+	h.w.Header().Set(contentLength, strconv.Itoa(h.buf.Len()))
+	h.w.writeHeldHeader()
+	_, err := h.w.ResponseWriter.Write(h.buf.Bytes())
+	h.buf = bytes.Buffer{}
+	return err
+}
+
+// writeHeldHeader writes the header held back by startGzip.
+func (w *GzipResponseWriter) writeHeldHeader() {
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+		w.code = 0
+	}
+}
@@ -0,0 +1,165 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTimingHandler(flush bool) http.Handler {
+	wrapper, _ := GzipHandlerWithOpts(ServerTiming(func(r *http.Request) bool {
+		return r.URL.Query().Get("debug") == "secret"
+	}))
+	return wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set(serverTiming, "db;dur=53")
+		io.WriteString(w, testBody)
+		if flush {
+			w.(http.Flusher).Flush()
+		}
+		io.WriteString(w, r.URL.Query().Get("body"))
+	}))
+}
+
+func TestServerTiming(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/?debug=secret", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	newTimingHandler(false).ServeHTTP(resp, req)
+	res := resp.Result()
+
+	assert.Equal(t, "gzip", res.Header.Get(contentEncoding))
+	assert.Equal(t, strconv.Itoa(resp.Body.Len()), res.Header.Get(contentLength))
+	if timings := res.Header.Values(serverTiming); assert.Len(t, timings, 2) {
+		assert.Equal(t, "db;dur=53", timings[0])
+		assert.Regexp(t, `^compress;dur=\d+\.\d{3};desc="gzip-6"$`, timings[1])
+	}
+	assert.Equal(t, strconv.Itoa(len(testBody)), res.Header.Get(xUncompressedContentLength))
+	ratio, err := strconv.ParseFloat(res.Header.Get(xCompressionRatio), 64)
+	assert.Nil(t, err)
+	assert.InDelta(t, float64(resp.Body.Len())/float64(len(testBody)), ratio, 0.0001)
+	assert.Empty(t, res.Header.Get(trailer))
+
+	gr, err := gzip.NewReader(resp.Body)
+	if assert.Nil(t, err) {
+		body, err := io.ReadAll(gr)
+		assert.Nil(t, err)
+		assert.Equal(t, testBody, string(body))
+	}
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/?debug=guess", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	newTimingHandler(false).ServeHTTP(resp, req)
+
+	assert.Equal(t, "gzip", resp.Header().Get(contentEncoding))
+	assert.Equal(t, []string{"db;dur=53"}, resp.Header().Values(serverTiming))
+	assert.Empty(t, resp.Header().Get(contentLength))
+	assert.Empty(t, resp.Header().Get(xUncompressedContentLength))
+	assert.Empty(t, resp.Header().Get(xCompressionRatio))
+}
+
+func TestServerTimingUncompressed(t *testing.T) {
+	handler := newTimingHandler(false)
+
+	req, _ := http.NewRequest("GET", "/?debug=secret", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, []string{"db;dur=53"}, resp.Header().Values(serverTiming))
+
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-1")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assert.Equal(t, []string{"db;dur=53"}, resp.Header().Values(serverTiming))
+
+	wrapper, _ := GzipHandlerWithOpts(ServerTiming(func(*http.Request) bool { return true }))
+	req.Header.Del("Range")
+	resp = httptest.NewRecorder()
+	wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, smallTestBody)
+	})).ServeHTTP(resp, req)
+	assert.Equal(t, []string{`compress;desc="too_small"`}, resp.Header().Values(serverTiming))
+	assert.Equal(t, smallTestBody, resp.Body.String())
+}
+
+func TestServerTimingCached(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(
+		CacheResponses(NewResponseCache(1<<20)),
+		ServerTiming(func(*http.Request) bool { return true }),
+	)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(etag, `"v1"`)
+		io.WriteString(w, testBody)
+	}))
+
+	var bodies []string
+	for _, want := range []string{`^compress;dur=\d+\.\d{3};desc="gzip-6"$`, `^compress;dur=0;desc="gzip-6 cached"$`} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assert.Regexp(t, want, resp.Header().Get(serverTiming))
+		assert.Equal(t, strconv.Itoa(resp.Body.Len()), resp.Header().Get(contentLength))
+		bodies = append(bodies, resp.Body.String())
+	}
+	assert.Equal(t, bodies[0], bodies[1])
+}
+
+func TestServerTimingHead(t *testing.T) {
+	req, _ := http.NewRequest("HEAD", "/?debug=secret", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	newTimingHandler(false).ServeHTTP(resp, req)
+
+	assert.Equal(t, "gzip", resp.Header().Get(contentEncoding))
+	assert.Equal(t, []string{"db;dur=53", `compress;dur=0;desc="gzip-6"`}, resp.Header().Values(serverTiming))
+	assert.Empty(t, resp.Header().Get(xUncompressedContentLength))
+	assert.Zero(t, resp.Body.Len())
+}
+
+func TestServerTimingStreamed(t *testing.T) {
+	srv := httptest.NewServer(newTimingHandler(true))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/?debug=secret&body=hello", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	assert.Equal(t, "gzip", res.Header.Get(contentEncoding))
+	assert.Equal(t, int64(-1), res.ContentLength)
+	assert.Equal(t, "db;dur=53", res.Header.Get(serverTiming))
+	assert.Empty(t, res.Header.Get(xUncompressedContentLength))
+
+	gr, err := gzip.NewReader(res.Body)
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, err := io.ReadAll(gr)
+	assert.Nil(t, err)
+	assert.Equal(t, testBody+"hello", string(body))
+
+	assert.Regexp(t, `^compress;dur=\d+\.\d{3};desc="gzip-6"$`, res.Trailer.Get(serverTiming))
+	assert.Equal(t, strconv.Itoa(len(testBody+"hello")), res.Trailer.Get(xUncompressedContentLength))
+	assert.NotEmpty(t, res.Trailer.Get(xCompressionRatio))
+}
+
+func TestTimingEntry(t *testing.T) {
+	assert.Equal(t, `compress;dur=1.500;desc="br-4"`, timingEntry(Decision{Reason: ReasonCompressed, Encoding: "br", Level: 4, CPUTime: 1500000}))
+	assert.Equal(t, `compress;dur=0.000;desc="deflate-6"`, timingEntry(Decision{Reason: ReasonCompressed, Encoding: "deflate", Level: -1}))
+	assert.Equal(t, `compress;dur=0.000;desc="custom"`, timingEntry(Decision{Reason: ReasonCompressed, Encoding: "custom"}))
+	assert.Equal(t, `compress;desc="opted_out"`, timingEntry(Decision{Reason: ReasonOptedOut}))
+	head := httptest.NewRequest("HEAD", "/", nil)
+	assert.Equal(t, `compress;dur=0;desc="zstd-3"`, timingEntry(Decision{Request: head, Reason: ReasonCompressed, Encoding: "zstd", Level: 3}))
+}
@@ -0,0 +1,229 @@
+package gziphandler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Transport is an http.RoundTripper which asks servers for compressed
+// responses using every content-coding it can decode, and transparently
+// decodes them, much like http.Transport does for gzip alone.
+//
+// As with http.Transport, requests which already have an Accept-Encoding
+// header are sent as-is, and their responses are left alone, since the caller
+// presumably wants to deal with the encoding itself. Responses using a
+// content-coding which can't be decoded, or more than four stacked
+// content-codings, are left alone too.
+//
+// If configured with UploadEncoding, it also compresses request bodies.
+type Transport struct {
+	base           http.RoundTripper
+	pools          map[string]*decoderPool
+	acceptEncoding string
+
+	upload  *encoderPool // Nil unless request bodies are compressed.
+	minSize int
+
+	// uncompressedHosts records the hosts which answered a compressed
+	// request with 415 Unsupported Media Type.
+	uncompressedHosts sync.Map
+}
+
+// NewTransport returns a Transport which sends requests through base, or
+// http.DefaultTransport if base is nil. The built-in gzip, deflate, br and
+// zstd content-codings are always decoded; others can be added with Decoders.
+func NewTransport(base http.RoundTripper, opts ...option) (*Transport, error) {
+	c, err := newConfig(forTransport, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	pools := c.decoderPools()
+	names := make([]string, len(pools))
+	for i, p := range pools {
+		names[i] = p.name
+	}
+
+	t := &Transport{
+		base:           base,
+		pools:          decoderPoolsByName(pools),
+		acceptEncoding: strings.Join(names, ", "),
+		minSize:        c.minSize,
+	}
+
+	if c.uploadEncoding != "" {
+		for _, p := range c.encoderPools() {
+			if p.name == strings.ToLower(c.uploadEncoding) {
+				t.upload = p
+			}
+		}
+		if t.upload == nil {
+			return nil, fmt.Errorf("upload encoding %q is not enabled", c.uploadEncoding)
+		}
+	}
+	return t, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.compressRequest(req) {
+		return t.roundTrip(req)
+	}
+
+	res, err := t.roundTrip(t.compressedRequest(req))
+	if err != nil || res.StatusCode != http.StatusUnsupportedMediaType {
+		return res, err
+	}
+
+	// The server doesn't accept compressed requests, so stop sending them
+	// and, if possible, try again uncompressed.
+	t.uncompressedHosts.Store(req.URL.Host, true)
+	if req.GetBody == nil {
+		return res, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return res, nil
+	}
+	// Drain a little of the body to give the connection a chance of being
+	// reused.
+	io.CopyN(io.Discard, res.Body, 4<<10)
+	res.Body.Close()
+
+	req = req.Clone(req.Context())
+	req.Body = body
+	return t.roundTrip(req)
+}
+
+// compressRequest returns whether the body of req should be compressed.
+func (t *Transport) compressRequest(req *http.Request) bool {
+	if t.upload == nil || req.Body == nil || req.Body == http.NoBody || req.Header.Get(contentEncoding) != "" {
+		return false
+	}
+	// A ContentLength of zero with a body means that it's unknown.
+	if req.ContentLength > 0 && req.ContentLength < int64(t.minSize) {
+		return false
+	}
+	_, uncompressed := t.uncompressedHosts.Load(req.URL.Host)
+	return !uncompressed
+}
+
+// compressedRequest returns a copy of req whose body is compressed with the
+// upload encoding.
+func (t *Transport) compressedRequest(req *http.Request) *http.Request {
+	r2 := req.Clone(req.Context())
+	r2.Body = t.compressBody(req.Body)
+	r2.ContentLength = -1
+	r2.Header.Set(contentEncoding, t.upload.name)
+	r2.Header.Del(contentLength)
+	if req.GetBody != nil {
+		r2.GetBody = func() (io.ReadCloser, error) {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			return t.compressBody(body), nil
+		}
+	}
+	return r2
+}
+
+// compressBody returns a reader of body compressed with the upload encoding.
+// The compression happens in its own goroutine as the returned reader is
+// read, which ends when it's closed, as the RoundTripper contract guarantees.
+func (t *Transport) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+
+		enc, _, err := t.upload.get(pw)
+		if err == nil {
+			_, err = io.Copy(enc, body)
+			if cerr := enc.Close(); err == nil {
+				err = cerr
+			}
+			t.upload.put(enc)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// roundTrip sends req, asking for a compressed response, and decodes it.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(acceptEncoding) != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set(acceptEncoding, t.acceptEncoding)
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// HEAD responses and the like have no body to decode, and their headers
+	// describe the body a GET would have returned.
+	if req.Method == http.MethodHead || res.Body == nil || res.Body == http.NoBody {
+		return res, nil
+	}
+
+	codings := contentCodings(res.Header)
+	if len(codings) == 0 {
+		return res, nil
+	}
+	body, ok := decodeBody(res.Body, codings, t.pools)
+	if !ok {
+		return res, nil
+	}
+
+	res.Body = &decodedBody{
+		ReadCloser: body,
+		encoding:   strings.Join(codings, ", "),
+	}
+	res.Header.Del(contentEncoding)
+	res.Header.Del(contentLength)
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// decodedBody is the body of a response decoded by Transport.
+type decodedBody struct {
+	io.ReadCloser
+	encoding string // The Content-Encoding the response was sent with.
+}
+
+// UploadEncoding makes the Transport returned by NewTransport compress request
+// bodies with the named content-coding, which must be enabled like it would
+// be for GzipHandlerWithOpts, e.g. with BrotliLevel for "br". Bodies known to
+// be smaller than MinSize are sent uncompressed.
+//
+// If the server answers a compressed request with 415 Unsupported Media Type,
+// the request is retried uncompressed, provided its body can be rewound with
+// GetBody, and further requests to the same host are sent uncompressed.
+func UploadEncoding(name string) option {
+	return scoped("UploadEncoding", forTransport, func(c *config) {
+		c.uploadEncoding = name
+	})
+}
+
+// ResponseEncoding returns the Content-Encoding of a response, as it was sent
+// by the server. For a response decoded by Transport, which removes the
+// Content-Encoding header, it returns the content-coding that was decoded.
+// It returns "" if the response wasn't encoded.
+func ResponseEncoding(res *http.Response) string {
+	if b, ok := res.Body.(*decodedBody); ok {
+		return b.encoding
+	}
+	return strings.Join(contentCodings(res.Header), ", ")
+}
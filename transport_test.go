@@ -0,0 +1,260 @@
+package gziphandler
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(
+		BrotliLevel(brotli.DefaultCompression),
+		ZstdLevel(zstd.SpeedDefault),
+		DeflateLevel(gzip.DefaultCompression),
+	)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+	var acceptEncoding string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		// Let the test pick the content-coding.
+		r.Header.Set("Accept-Encoding", r.URL.Query().Get("ae"))
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	client := &http.Client{Transport: tr}
+
+	for _, encoding := range []string{"identity", "gzip", "deflate", "br", "zstd"} {
+		res, err := client.Get(srv.URL + "?ae=" + encoding)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "zstd, br, gzip, deflate", acceptEncoding)
+		assert.Equal(t, testBody, string(body), encoding)
+		assert.Equal(t, "", res.Header.Get("Content-Encoding"), encoding)
+		assert.Equal(t, "", res.Header.Get("Content-Length"), encoding)
+		if encoding == "identity" {
+			assert.Equal(t, "", ResponseEncoding(res))
+		} else {
+			assert.Equal(t, encoding, ResponseEncoding(res))
+			assert.Equal(t, int64(-1), res.ContentLength, encoding)
+			assert.True(t, res.Uncompressed, encoding)
+		}
+	}
+}
+
+func TestTransportAcceptEncodingSet(t *testing.T) {
+	srv := httptest.NewServer(newTestHandler(testBody))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil)
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := tr.RoundTrip(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	// The response is left for the caller to decode.
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "gzip", ResponseEncoding(res))
+	assert.Equal(t, gzipStrLevel(testBody, gzip.DefaultCompression), body)
+	assert.Equal(t, "gzip", req.Header.Get("Accept-Encoding"))
+}
+
+func TestTransportDoesNotModifyRequest(t *testing.T) {
+	srv := httptest.NewServer(newTestHandler(testBody))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil)
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	res, err := tr.RoundTrip(req)
+	if !assert.Nil(t, err) {
+		return
+	}
+	res.Body.Close()
+
+	assert.Equal(t, "", req.Header.Get("Accept-Encoding"))
+}
+
+func TestTransportHead(t *testing.T) {
+	srv := httptest.NewServer(newTestHandler(testBody))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil)
+	res, err := (&http.Client{Transport: tr}).Head(srv.URL)
+	if !assert.Nil(t, err) {
+		return
+	}
+	res.Body.Close()
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+}
+
+func TestTransportDecoders(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(Encoders(upperEncoderFactory{}))
+	srv := httptest.NewServer(wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello, "+testBody)
+	})))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil, Decoders(lowerDecoderFactory{}))
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, "x-upper", ResponseEncoding(res))
+	assert.Equal(t, "hello, "+testBody, string(body))
+}
+
+func TestTransportTooManyCodings(t *testing.T) {
+	contentEncoding := strings.TrimSuffix(strings.Repeat("x-upper, ", 10000), ", ")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", contentEncoding)
+		io.WriteString(w, "HELLO")
+	}))
+	defer srv.Close()
+
+	var news int
+	tr, _ := NewTransport(nil, Decoders(countingDecoderFactory{&news}))
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if !assert.Nil(t, err) {
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Equal(t, contentEncoding, res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "HELLO", string(body))
+	assert.Zero(t, news)
+}
+
+func TestTransportUploadEncoding(t *testing.T) {
+	var (
+		body            []byte
+		contentEncoding string
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentEncoding = r.Header.Get("Content-Encoding")
+		DecompressHandler(handler).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		opts             []option
+		body             string
+		expectedEncoding string
+	}{
+		{[]option{UploadEncoding("gzip")}, testBody, "gzip"},
+		{[]option{UploadEncoding("gzip")}, smallTestBody, ""},
+		{[]option{UploadEncoding("gzip"), MinSize(0)}, smallTestBody, "gzip"},
+		{[]option{UploadEncoding("br"), BrotliLevel(brotli.DefaultCompression)}, testBody, "br"},
+		{[]option{UploadEncoding("ZSTD"), ZstdLevel(zstd.SpeedFastest)}, testBody, "zstd"},
+		{nil, testBody, ""},
+	}
+
+	for _, tt := range tests {
+		tr, err := NewTransport(nil, tt.opts...)
+		if !assert.Nil(t, err) {
+			continue
+		}
+		res, err := (&http.Client{Transport: tr}).Post(srv.URL, "text/plain", strings.NewReader(tt.body))
+		if !assert.Nil(t, err) {
+			continue
+		}
+		res.Body.Close()
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, tt.expectedEncoding, contentEncoding)
+		assert.Equal(t, tt.body, string(body))
+	}
+
+	_, err := NewTransport(nil, UploadEncoding("br"))
+	assert.Error(t, err)
+}
+
+func TestTransportUploadUnsupported(t *testing.T) {
+	var (
+		requests []string
+		body     []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil, UploadEncoding("gzip"))
+	client := &http.Client{Transport: tr}
+
+	// The first request is retried uncompressed, and the next one isn't
+	// compressed to begin with.
+	for i := 0; i < 2; i++ {
+		res, err := client.Post(srv.URL, "text/plain", strings.NewReader(testBody))
+		if !assert.Nil(t, err) {
+			return
+		}
+		res.Body.Close()
+
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, testBody, string(body))
+	}
+	assert.Equal(t, []string{"gzip", "", ""}, requests)
+}
+
+func TestTransportUploadUnsupportedNotRewindable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+		}
+	}))
+	defer srv.Close()
+
+	tr, _ := NewTransport(nil, UploadEncoding("gzip"))
+	client := &http.Client{Transport: tr}
+
+	res, err := client.Post(srv.URL, "text/plain", ioutil.NopCloser(strings.NewReader(testBody)))
+	if !assert.Nil(t, err) {
+		return
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode)
+
+	// The host is still remembered.
+	res, err = client.Post(srv.URL, "text/plain", ioutil.NopCloser(strings.NewReader(testBody)))
+	if !assert.Nil(t, err) {
+		return
+	}
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+}
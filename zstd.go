@@ -0,0 +1,142 @@
+package gziphandler
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdWriterPools stores a *sync.Pool for each zstdEncoderFactory (i.e. each
+// combination of level and window size) in use, for reuse of zstd.Encoders.
+// Unlike gzipWriterPools these are created lazily, since there are too many
+// window sizes to create them all up front.
+var zstdWriterPools sync.Map
+
+// zstdEncoderFactory is the built-in EncoderFactory for the zstd
+// content-coding. Its Encoders are pooled in zstdWriterPools.
+type zstdEncoderFactory struct {
+	level      zstd.EncoderLevel
+	windowSize int // Zero means the default for the level.
+}
+
+func (f zstdEncoderFactory) Name() string {
+	return "zstd"
+}
+
+func (f zstdEncoderFactory) NewEncoder(w io.Writer) (Encoder, error) {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(f.level),
+		// Every response is compressed by its own Encoder, so there's
+		// nothing to gain from the extra goroutines.
+		zstd.WithEncoderConcurrency(1),
+	}
+	if f.windowSize != 0 {
+		opts = append(opts, zstd.WithWindowSize(f.windowSize))
+	}
+
+	zw, err := zstd.NewWriter(w, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+func (f zstdEncoderFactory) pool() *sync.Pool {
+	p, _ := zstdWriterPools.LoadOrStore(f, &sync.Pool{})
+	return p.(*sync.Pool)
+}
+
+func (f zstdEncoderFactory) compressionLevel() int {
+	return int(f.level)
+}
+
+func (f zstdEncoderFactory) validate() error {
+	if f.level < zstd.SpeedFastest || f.level > zstd.SpeedBestCompression {
+		return fmt.Errorf("invalid zstd level requested: %d", f.level)
+	}
+	if f.windowSize != 0 && (f.windowSize < zstd.MinWindowSize || f.windowSize > zstd.MaxWindowSize || f.windowSize&(f.windowSize-1) != 0) {
+		return fmt.Errorf("invalid zstd window size requested: %d", f.windowSize)
+	}
+	return nil
+}
+
+// zstdMaxWindowSize is the largest window RFC 9659 allows the zstd
+// content-coding to use. The window is allocated up front, so Decoders
+// refuse frames declaring a larger one rather than trusting the sender.
+const zstdMaxWindowSize = 8 << 20
+
+// zstdReaderPools stores a *sync.Pool of zstdDecoders for each
+// zstdDecoderFactory in use, like zstdWriterPools.
+var zstdReaderPools sync.Map
+
+// zstdDecoderFactory is the built-in DecoderFactory for the zstd
+// content-coding. Its Decoders are pooled in zstdReaderPools.
+type zstdDecoderFactory struct {
+	// maxMemory, if positive, lowers the largest window the Decoders accept
+	// below zstdMaxWindowSize. It's the MaxDecompressedSize, since a frame
+	// whose window is larger than that is no use.
+	maxMemory int64
+}
+
+func (f zstdDecoderFactory) Name() string {
+	return "zstd"
+}
+
+func (f zstdDecoderFactory) NewDecoder(r io.Reader) (Decoder, error) {
+	// With a concurrency of one, streams are decoded synchronously, so
+	// pooled Decoders don't hold on to any goroutines.
+	opts := []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(zstdMaxWindowSize),
+	}
+	if f.maxMemory > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(uint64(f.maxMemory)))
+	}
+
+	zr, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return zstdDecoder{zr}, nil
+}
+
+func (f zstdDecoderFactory) pool() *sync.Pool {
+	p, _ := zstdReaderPools.LoadOrStore(f, &sync.Pool{})
+	return p.(*sync.Pool)
+}
+
+// zstdDecoder adapts zstd.Decoder to Decoder. zstd.Decoder.Close releases
+// the Decoder for good, so it's never called.
+type zstdDecoder struct {
+	*zstd.Decoder
+}
+
+func (d zstdDecoder) Close() error {
+	return nil
+}
+
+// ZstdLevel enables the zstd content-coding (RFC 8878), compressing at the
+// given level. zstd.SpeedDefault compresses about as well as gzip's default
+// level at a fraction of the CPU cost.
+//
+// When a client accepts zstd along with other content-codings with the same
+// qvalue, zstd is preferred unless PreferredEncodings says otherwise.
+func ZstdLevel(level zstd.EncoderLevel) option {
+	return scoped("ZstdLevel", compressing|forTransport, func(c *config) {
+		c.zstd = true
+		c.zstdLevel = level
+	})
+}
+
+// ZstdWindowSize sets the maximum back-reference distance used by the zstd
+// content-coding enabled with ZstdLevel. It must be a power of two between
+// zstd.MinWindowSize and zstd.MaxWindowSize. Larger windows compress better
+// but use more memory on both ends; browsers are only required to support
+// windows up to 8MB, which is also the default.
+func ZstdWindowSize(size int) option {
+	return scoped("ZstdWindowSize", compressing|forTransport, func(c *config) {
+		c.zstdWindowSize = size
+	})
+}
@@ -0,0 +1,87 @@
+package gziphandler
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZstdLevel(t *testing.T) {
+	for lvl := zstd.SpeedFastest; lvl <= zstd.SpeedBestCompression; lvl++ {
+		for _, ws := range []int{0, zstd.MinWindowSize, 1 << 20} {
+			wrapper, err := GzipHandlerWithOpts(ZstdLevel(lvl), ZstdWindowSize(ws))
+			if !assert.Nil(t, err, "GzipHandlerWithOpts returned error for zstd level:", lvl, ws) {
+				continue
+			}
+
+			req, _ := http.NewRequest("GET", "/whatever", nil)
+			req.Header.Set("Accept-Encoding", "zstd")
+			resp := httptest.NewRecorder()
+			wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, testBody)
+			})).ServeHTTP(resp, req)
+			res := resp.Result()
+
+			assert.Equal(t, 200, res.StatusCode)
+			assert.Equal(t, "zstd", res.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			assert.Equal(t, testBody, zstdDecode(t, resp.Body.Bytes()))
+		}
+	}
+}
+
+func TestZstdLevelReturnsErrorForInvalidOptions(t *testing.T) {
+	_, err := GzipHandlerWithOpts(ZstdLevel(zstd.SpeedFastest - 1))
+	assert.NotNil(t, err, "Expected error for invalid zstd level")
+	_, err = GzipHandlerWithOpts(ZstdLevel(zstd.SpeedBestCompression + 1))
+	assert.NotNil(t, err, "Expected error for invalid zstd level")
+	_, err = GzipHandlerWithOpts(ZstdLevel(zstd.SpeedDefault), ZstdWindowSize(3000))
+	assert.NotNil(t, err, "Expected error for invalid zstd window size")
+	_, err = GzipHandlerWithOpts(ZstdLevel(zstd.SpeedDefault), ZstdWindowSize(zstd.MinWindowSize/2))
+	assert.NotNil(t, err, "Expected error for invalid zstd window size")
+}
+
+func TestZstdNegotiation(t *testing.T) {
+	wrapper, _ := GzipHandlerWithOpts(ZstdLevel(zstd.SpeedDefault), BrotliLevel(brotli.DefaultCompression))
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, testBody)
+	}))
+
+	tests := map[string]string{
+		"zstd":                    "zstd",
+		"gzip, deflate, br, zstd": "zstd",
+		"gzip, br, zstd;q=0.9":    "br",
+		"gzip, zstd;q=0":          "gzip",
+		"gzip;q=0.1, zstd;q=0.2":  "zstd",
+		"identity":                "",
+	}
+
+	for ae, expected := range tests {
+		req, _ := http.NewRequest("GET", "/whatever", nil)
+		req.Header.Set("Accept-Encoding", ae)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		assert.Equal(t, expected, resp.Header().Get("Content-Encoding"), ae)
+	}
+}
+
+// --------------------------------------------------------------------
+
+func zstdDecode(t *testing.T, b []byte) string {
+	zr, err := zstd.NewReader(bytes.NewReader(b))
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer zr.Close()
+	out, err := ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+	return string(out)
+}